@@ -0,0 +1,155 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Source archive extraction, for building from tarballs or zips instead of VCS.
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extracts a .tar.gz (.tgz) or .zip source archive into a fresh temporary folder,
+// returning the folder itself (to be removed afterwards) and the root of the sources
+// in it. If all the contents are wrapped in a single top level folder (e.g. GitHub
+// release archives), that folder is the source root.
+func extractSourceArchive(archive string) (string, string, error) {
+	dir, err := os.MkdirTemp("", "xgo-src-")
+	if err != nil {
+		return "", "", err
+	}
+	switch name := strings.ToLower(archive); {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		err = extractTarball(archive, dir)
+	case strings.HasSuffix(name, ".zip"):
+		err = extractZip(archive, dir)
+	default:
+		err = fmt.Errorf("unsupported archive format (must be .tar.gz, .tgz or .zip)")
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+	root := dir
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(dir, entries[0].Name())
+	}
+	return dir, root, nil
+}
+
+// Extracts a gzipped tarball into a folder, skipping anything but plain files and
+// folders (e.g. links, devices).
+func extractTarball(archive string, dir string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			path, err := archivePath(dir, header.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(dir, header.Name, os.FileMode(header.Mode).Perm(), reader); err != nil {
+				return err
+			}
+		default:
+			debugf("Skipping non-regular archive entry %s", header.Name)
+		}
+	}
+}
+
+// Extracts a zip archive into a folder.
+func extractZip(archive string, dir string) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			path, err := archivePath(dir, entry.Name)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Mode().IsRegular() {
+			debugf("Skipping non-regular archive entry %s", entry.Name)
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(dir, entry.Name, entry.Mode().Perm(), content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes a single archive entry into the extraction folder, creating its parents.
+func extractFile(dir string, name string, mode os.FileMode, content io.Reader) error {
+	path, err := archivePath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Resolves the path of an archive entry within the extraction folder, rejecting
+// entries that would escape it (e.g. ../ components or absolute paths).
+func archivePath(dir string, name string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %s escapes the extraction folder", name)
+	}
+	return path, nil
+}
@@ -0,0 +1,78 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Buffering of the container's output for -summary-only, replaying only the logs
+// of the failed targets.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// logBuffer collects the output of a build container instead of streaming it,
+// safe for the concurrent stdout and stderr copiers.
+type logBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *logBuffer) Write(data []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buffer.Write(data)
+}
+
+// Splits the buffered output into sections, one per target built as announced by
+// the build script's progress lines, the first one holding everything before.
+func (b *logBuffer) sections() [][]string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sections := [][]string{nil}
+	for _, line := range strings.Split(strings.TrimRight(b.buffer.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "Compiling for ") {
+			sections = append(sections, nil)
+		}
+		sections[len(sections)-1] = append(sections[len(sections)-1], line)
+	}
+	return sections
+}
+
+// Replays the buffered output of a failed build: the sections of the targets that
+// failed while the build kept going (along with the final totals), or otherwise the
+// one it aborted in.
+func (b *logBuffer) replayFailures(w io.Writer) {
+	sections := b.sections()
+	if len(sections) == 1 && strings.Join(sections[0], "") == "" {
+		return // Nothing was output at all
+	}
+	var failed [][]string
+	for _, section := range sections[1:] {
+		for _, line := range section {
+			if strings.HasPrefix(line, "Failed to build ") && strings.HasSuffix(line, ", continuing with the remaining targets...") {
+				failed = append(failed, section)
+				break
+			}
+		}
+	}
+	last := sections[len(sections)-1]
+	if len(failed) == 0 {
+		failed = [][]string{last}
+	} else if total := last[len(last)-1]; strings.HasPrefix(total, "Failed to build ") && strings.Contains(total, " target(s): ") {
+		defer fmt.Fprintln(w, total)
+	}
+	for _, section := range failed {
+		header := "Output of the failed build"
+		if strings.HasPrefix(section[0], "Compiling for ") {
+			header += " for " + strings.TrimSuffix(strings.TrimPrefix(section[0], "Compiling for "), "...")
+		}
+		fmt.Fprintf(w, "%s:\n%s\n", header, strings.Join(section, "\n"))
+	}
+}
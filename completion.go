@@ -0,0 +1,130 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Shell completion script generators.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Go releases for which an xgo image is published (see the docker folder).
+var knownReleases = []string{"tip", "latest", "1.4.x", "1.4.2", "1.4", "1.3.x", "1.3.3", "1.3.1", "1.3.0"}
+
+// Completion script generators for the supported shells.
+var completers = map[string]func() string{
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// Generates the shell completion script for the requested shell.
+func completion(shell string) (string, error) {
+	gen, ok := completers[shell]
+	if !ok {
+		var shells []string
+		for name := range completers {
+			shells = append(shells, name)
+		}
+		sort.Strings(shells)
+		return "", fmt.Errorf("unsupported shell %s (must be one of %s)", shell, strings.Join(shells, ", "))
+	}
+	return gen(), nil
+}
+
+// Returns the names of all the known targets.
+func targetNames() []string {
+	names := make([]string, len(knownTargets))
+	for i, target := range knownTargets {
+		names[i] = target.Name
+	}
+	return names
+}
+
+// Checks whether a command line flag is a boolean one (i.e. takes no value).
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// Generates a bash completion script, completing flag names, (comma separated)
+// target names and Go releases.
+func bashCompletion() string {
+	var flags []string
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+	})
+	return fmt.Sprintf(`# bash completion for xgo, install via: source <(xgo -completion bash)
+_xgo() {
+  local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+  case "$prev" in
+    -targets|--targets)
+      local prefix=""
+      if [[ "$cur" == *,* ]]; then prefix="${cur%%,*},"; fi
+      COMPREPLY=($(compgen -P "$prefix" -W "all %s" -- "${cur##*,}"))
+      return ;;
+    -go|--go)
+      COMPREPLY=($(compgen -W "%s" -- "$cur"))
+      return ;;
+  esac
+  if [[ "$cur" == -* ]]; then
+    COMPREPLY=($(compgen -W "%s" -- "$cur"))
+  fi
+}
+complete -o default -F _xgo xgo
+`, strings.Join(targetNames(), " "), strings.Join(knownReleases, " "), strings.Join(flags, " "))
+}
+
+// Generates a zsh completion script based on the _arguments helper.
+func zshCompletion() string {
+	escape := strings.NewReplacer("[", "\\[", "]", "\\]", ":", "\\:", "'", "'\\''")
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "#compdef xgo\n# zsh completion for xgo, install via: xgo -completion zsh > \"${fpath[1]}/_xgo\"\n")
+	fmt.Fprintf(buf, "_arguments \\\n")
+	flag.VisitAll(func(f *flag.Flag) {
+		spec := fmt.Sprintf("-%s[%s]", f.Name, escape.Replace(f.Usage))
+		switch {
+		case isBoolFlag(f):
+		case f.Name == "targets":
+			spec += ":targets:_values -s , target all " + strings.Join(targetNames(), " ")
+		case f.Name == "go":
+			spec += ":release:(" + strings.Join(knownReleases, " ") + ")"
+		default:
+			spec += ":" + f.Name + ":"
+		}
+		fmt.Fprintf(buf, "  '%s' \\\n", spec)
+	})
+	fmt.Fprintf(buf, "  '1:import path:'\n")
+	return buf.String()
+}
+
+// Generates a fish completion script.
+func fishCompletion() string {
+	escape := strings.NewReplacer("\\", "\\\\", "'", "\\'")
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# fish completion for xgo, install via: xgo -completion fish > ~/.config/fish/completions/xgo.fish\n")
+	flag.VisitAll(func(f *flag.Flag) {
+		line := fmt.Sprintf("complete -c xgo -o %s -d '%s'", f.Name, escape.Replace(f.Usage))
+		switch {
+		case isBoolFlag(f):
+		case f.Name == "targets":
+			line += " -x -a '(__fish_complete_list , \"string join \\n all " + strings.Join(targetNames(), " ") + "\")'"
+		case f.Name == "go":
+			line += " -x -a '" + strings.Join(knownReleases, " ") + "'"
+		default:
+			line += " -r"
+		}
+		fmt.Fprintln(buf, line)
+	})
+	return buf.String()
+}
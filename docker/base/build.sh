@@ -10,11 +10,70 @@
 #   REPO_REMOTE - Optional VCS remote if not the primary repository is needed
 #   REPO_BRANCH - Optional VCS branch to use, if not the master branch
 #   DEPS        - Optional list of C dependency packages to build
+#   DEPS_SHA256 - Optional expected SHA256 checksums of the DEPS archives, in order (- to skip)
+#   MODULE_ROOT - Optional repository sub-folder holding the Go module to build
 #   PACK        - Optional sub-package, if not the import path is being built
-#   OUT         - Optional output prefix to override the package name
+#   OUT         - Optional output prefix to override the package name (set by xgo)
+#   FLAG_NAME_<TARGET> - Optional exact output file name of a target, overriding OUT
+#   KEEP_GOING  - Optional flag to continue with the other targets if one fails
+#   FAIL_ON_WARNING - Optional flag to fail targets whose build reports warnings
 #   FLAG_V      - Optional verbosity flag to set on the Go builder
-#   FLAG_RACE   - Optional race flag to set on the Go builder
-#   TARGETS     - Optional comma delimited list of targets arch to build
+#   RACE_TARGETS - Optional comma delimited list of targets to build with -race
+#   MSAN_TARGETS - Optional comma delimited list of targets to build with -msan
+#   ASAN_TARGETS - Optional comma delimited list of targets to build with -asan
+#   FLAG_TAGS   - Optional tag flag to set on the Go builder
+#   FLAG_TAGS_<TARGET> - Optional tag flag to set instead for a target (already merged)
+#   FLAG_TESTBIN - Optional flag to build test binaries via go test -c
+#   FLAG_GOAMD64 - Optional microarchitecture level to set on amd64 builds
+#   FLAG_GO386  - Optional floating point instruction set to set on 386 builds
+#   FLAG_ARGS   - Optional newline separated extra arguments to pass to go build
+#   FLAG_GOEXPERIMENT - Optional Go toolchain experiments to enable (GOEXPERIMENT)
+#   FLAG_GODEBUG - Optional comma separated GODEBUG defaults to bake into the binaries
+#   FLAG_LDFLAGS - Optional linker flags to set on the Go builder
+#   FLAG_LDFLAGS_<TARGET> - Optional linker flags to set instead for a target
+#   PRE_BUILD   - Optional shell command to run once before building any target
+#   FLAG_GENERATE - Optional flag to run go generate ./... before building any target
+#   FLAG_COMPRESS - Optional flag to compress the produced binaries with UPX
+#   SKIP_EXISTING - Optional flag to skip targets whose outputs are up to date
+#   NO_CLOBBER  - Optional flag to fail targets whose outputs already exist
+#   CLEAN_BUILD - Optional flag to clear the Go caches and rebuild all packages (-a)
+#   ONLY_CHANGED - Optional flag to skip targets whose inputs match PREV_INPUTS_<TARGET>
+#   PREV_INPUTS_<TARGET> - Optional input fingerprint of a target from a previous build
+#   IMAGE_ID    - Optional content digest of the image, fingerprinted with ONLY_CHANGED
+#   BUILD_DIR   - Optional folder to place the outputs into (defaults to /build)
+#   OWNER       - Optional uid:gid to hand the outputs over to (rootful daemons only)
+#   TARGETS     - Comma delimited list of targets to build (e.g. linux-amd64)
+#   FLAG_CGO_<TARGET> - Optional CGO_ENABLED value for a target (defaults to 1)
+#   CC_<TARGET> - Optional C compiler to use for a target (e.g. CC_LINUX_ARM)
+#   FLAG_BUILDMODE_<TARGET> - Optional Go build mode to use for a target
+#   FLAG_EXT_<TARGET> - Optional output file extension of a target (e.g. .exe, .so)
+#   CGO_CXXFLAGS_<TARGET> - Optional C++ compiler flags for the CGO code of a target
+#   CGO_CPPFLAGS_<TARGET> - Optional C preprocessor flags for the CGO code of a target
+#   FLAG_MACOSX_MIN - Optional minimum macOS release to set on darwin builds
+#   SOURCE_DATE_EPOCH - Optional release timestamp for reproducible C macros (__DATE__)
+#   ANDROID_NDK_ROOT - Android NDK location, needed for the android targets only
+#   ANDROID_API - Optional Android API level to target (defaults to 21)
+#
+# Produced outputs besides the binaries:
+#   $BUILD_DIR/.xgo-report - Build metadata (Go version, revision, dependency checksums,
+#                            built and skipped targets, input fingerprints) for the host
+
+# Place the outputs into the mounted output folder, wherever the host mounted it
+BUILD_DIR=${BUILD_DIR:-/build}
+
+# Hand the outputs over to the invoking host user when done, even if failing. The
+# outputs (C headers included) are tracked as written, as exact names (FLAG_NAME_*)
+# don't share a prefix.
+OUTPUTS=()
+if [ "$OWNER" != "" ]; then
+  trap 'chown -R $OWNER $REPORT "${OUTPUTS[@]}" 2> /dev/null' EXIT
+fi
+
+# Start from empty Go caches if a clean build was requested (old releases lack them)
+if [ "$CLEAN_BUILD" == "true" ]; then
+  echo "Clearing the Go build and module caches..."
+  go clean -cache -modcache 2> /dev/null || true
+fi
 
 # Download the canonical import path (may fail, don't allow failures beyond)
 echo "Fetching main repository $1..."
@@ -47,73 +106,329 @@ if [ "$REPO_BRANCH" != "" ]; then
   fi
 fi
 
+# Start the build report with the metadata only known inside the container
+REPORT=$BUILD_DIR/.xgo-report
+echo "go `go version | awk '{print $3}'`" > $REPORT
+if [ -d ".git" ]; then
+  echo "revision `git rev-parse HEAD`" >> $REPORT
+elif [ -d ".hg" ]; then
+  echo "revision `hg id -i`" >> $REPORT
+fi
+
+# Switch into the module root if it's not the root of the repository
+if [ "$MODULE_ROOT" != "" ]; then
+  echo "Switching over to module root $MODULE_ROOT..."
+  cd $MODULE_ROOT
+fi
+
 # Download all the C dependencies
 echo "Fetching dependencies..."
 mkdir /deps
-DEPS=($DEPS) && for dep in "${DEPS[@]}"; do
+DEPS=($DEPS) && DEPS_SHA256=($DEPS_SHA256) && for i in "${!DEPS[@]}"; do
+  dep=${DEPS[$i]} want=${DEPS_SHA256[$i]}
   echo Downloading $dep
-  if [ "${dep##*.}" == "tar" ]; then wget -q $dep -O - | tar -C /deps -x; fi
-  if [ "${dep##*.}" == "gz" ]; then wget -q $dep -O - | tar -C /deps -xz; fi
-  if [ "${dep##*.}" == "bz2" ]; then wget -q $dep -O - | tar -C /deps -xj; fi
+  wget -q $dep -O /tmp/xgo-dep
+
+  # Verify the archive against its expected checksum if any, and report the actual one
+  sum=`sha256sum /tmp/xgo-dep | cut -d ' ' -f 1`
+  if [ "$want" != "" ] && [ "$want" != "-" ] && [ "$want" != "$sum" ]; then
+    echo "Checksum mismatch for $dep: expected $want, got $sum"
+    exit 1
+  fi
+  echo "dep $dep $sum" >> $REPORT
+
+  if [ "${dep##*.}" == "tar" ]; then tar -C /deps -xf /tmp/xgo-dep; fi
+  if [ "${dep##*.}" == "gz" ]; then tar -C /deps -xzf /tmp/xgo-dep; fi
+  if [ "${dep##*.}" == "bz2" ]; then tar -C /deps -xjf /tmp/xgo-dep; fi
+  rm /tmp/xgo-dep
 done
 
+# Run any source generation steps before fingerprinting and building the targets
+if [ "$PRE_BUILD" != "" ]; then
+  echo "Running pre-build command..."
+  bash -c "$PRE_BUILD"
+fi
+if [ "$FLAG_GENERATE" == "true" ]; then
+  echo "Generating sources..."
+  go generate -tags "$FLAG_TAGS" ./...
+fi
+
+# Fingerprint all the build inputs (sources, dependencies and toolchain) to allow
+# skipping up to date or unchanged targets if requested
+if [ "$SKIP_EXISTING" == "true" ] || [ "$ONLY_CHANGED" == "true" ]; then
+  SOURCE_HASH=`(go version; find . /deps -type f ! -path '*/.git/*' ! -path '*/.hg/*' -print0 | sort -z | xargs -0 sha1sum) | sha1sum | cut -d ' ' -f 1`
+fi
+
 # Configure some global build parameters
-NAME=`basename $1/$PACK`
+NAME=`basename $1/$MODULE_ROOT/$PACK`
 if [ "$OUT" != "" ]; then
   NAME=$OUT
 fi
 
 if [ "$FLAG_V" == "true" ]; then V=-v; fi
-if [ "$FLAG_RACE" == "true" ]; then R=-race; fi
+if [ "$FLAG_TAGS" != "" ]; then T=(-tags "$FLAG_TAGS"); fi
+if [ "$FLAG_ARGS" != "" ]; then mapfile -t A <<< "$FLAG_ARGS"; fi
+if [ "$CLEAN_BUILD" == "true" ]; then A+=(-a); fi
 
-# Build for each platform individually
-if [ "${LINUX64}" = "true" ];then
-    echo "Compiling for linux/amd64..."
-    HOST=x86_64-linux PREFIX=/usr/local $BUILD_DEPS /deps
-    GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go get -d ./$PACK
-    GOOS=linux GOARCH=amd64 CGO_ENABLED=1 go build $V $R -o $NAME-linux-amd64$R ./$PACK
+# Bake the GODEBUG defaults into the binaries via the godebug directives of a scratch
+# copy of go.mod, leaving the (possibly mounted) sources untouched
+if [ "$FLAG_GODEBUG" != "" ]; then
+  if [ ! -f go.mod ]; then
+    echo "GODEBUG defaults need a Go module, no go.mod found"
+    exit 1
+  fi
+  cp go.mod /tmp/xgo-godebug.mod
+  if [ -f go.sum ]; then cp go.sum /tmp/xgo-godebug.sum; fi
+  for setting in ${FLAG_GODEBUG//,/ }; do
+    go mod edit -godebug=$setting /tmp/xgo-godebug.mod || {
+      echo "Failed to set GODEBUG default $setting, go.mod godebug directives need Go 1.23 or newer"
+      exit 1
+    }
+  done
+  A+=(-modfile=/tmp/xgo-godebug.mod)
 fi
+if [ "$FLAG_LDFLAGS" != "" ]; then LD=(-ldflags "$FLAG_LDFLAGS"); fi
 
-if [ "${LINUX386}" = "true" ];then
-    echo "Compiling for linux/386..."
-    HOST=i686-linux PREFIX=/usr/local $BUILD_DEPS /deps
-    GOOS=linux GOARCH=386 CGO_ENABLED=1 go get -d ./$PACK
-    GOOS=linux GOARCH=386 CGO_ENABLED=1 go build $V -o $NAME-linux-386 ./$PACK
-fi
+# Select between building executables and test binaries
+GO_CMD=build
+if [ "$FLAG_TESTBIN" == "true" ]; then GO_CMD="test -c"; GET_T=-t; EXT=.test; fi
 
-if [ "${LINUXARM}" = "true" ];then
-    echo "Compiling for linux/arm..."
-    CC=arm-linux-gnueabi-gcc HOST=arm-linux PREFIX=/usr/local/arm $BUILD_DEPS /deps
-    CC=arm-linux-gnueabi-gcc GOOS=linux GOARCH=arm CGO_ENABLED=1 GOARM=5 go get -d ./$PACK
-    CC=arm-linux-gnueabi-gcc GOOS=linux GOARCH=arm CGO_ENABLED=1 GOARM=5 go build $V -o $NAME-linux-arm ./$PACK
+# Ensure there's a command to build (test binaries can be built from any package),
+# failing with EX_USAGE otherwise so the host can tell it from a build failure
+if [ "$FLAG_TESTBIN" != "true" ]; then
+  PKG_NAME=`go list -e -f '{{.Name}}' "${T[@]}" ./$PACK 2> /dev/null`
+  if [ "$PKG_NAME" != "" ] && [ "$PKG_NAME" != "main" ]; then
+    echo "Package $1/$PACK is a library (package $PKG_NAME), not a command: there is nothing to output"
+    COMMANDS=`go list -e -f '{{if eq .Name "main"}}{{.ImportPath}}{{end}}' "${T[@]}" ./... 2> /dev/null | sed "s|^$1/||" | head -n 5`
+    if [ "$COMMANDS" != "" ]; then
+      echo "Select one of its commands via -pkg, e.g.:"
+      echo "$COMMANDS" | sed 's/^/  -pkg /'
+    fi
+    exit 64
+  fi
 fi
 
-if [ "${WINDOWS64}" = "true" ];then
-    echo "Compiling for windows/amd64..."
-    CC=x86_64-w64-mingw32-gcc HOST=x86_64-w64-mingw32 PREFIX=/usr/x86_64-w64-mingw32 $BUILD_DEPS /deps
-    CC=x86_64-w64-mingw32-gcc GOOS=windows GOARCH=amd64 CGO_ENABLED=1 go get -d ./$PACK
-    CC=x86_64-w64-mingw32-gcc GOOS=windows GOARCH=amd64 CGO_ENABLED=1 go build $V $R -o $NAME-windows-amd64$R.exe ./$PACK
-fi
+# Checks whether a comma delimited list contains a specific item.
+#
+# Usage: in_list <item> <list>
+function in_list {
+  [[ ",$2," == *",$1,"* ]]
+}
 
-if [ "${WINDOWS386}" = "true" ];then
-    echo "Compiling for windows/386..."
-    CC=i686-w64-mingw32-gcc HOST=i686-w64-mingw32 PREFIX=/usr/i686-w64-mingw32 $BUILD_DEPS /deps
-    CC=i686-w64-mingw32-gcc GOOS=windows GOARCH=386 CGO_ENABLED=1 go get -d ./$PACK
-    CC=i686-w64-mingw32-gcc GOOS=windows GOARCH=386 CGO_ENABLED=1 go build $V -o $NAME-windows-386.exe ./$PACK
-fi
+# Retrieves a per target setting from its environment variable, named after the
+# setting and the upper cased target (e.g. target_var CC linux-arm -> CC_LINUX_ARM).
+#
+# Usage: target_var <name> <target>
+function target_var {
+  local key=${1}_${2//-/_}
+  key=${key^^}
+  echo "${!key}"
+}
 
-if [ "${DARWIN64}" = "true" ];then
-    echo "Compiling for darwin/amd64..."
-    CC=o64-clang HOST=x86_64-apple-darwin10 PREFIX=/usr/local $BUILD_DEPS /deps
-    CC=o64-clang GOOS=darwin GOARCH=amd64 CGO_ENABLED=1 go get -d ./$PACK
-    CC=o64-clang GOOS=darwin GOARCH=amd64 CGO_ENABLED=1 go build $V $R -o $NAME-darwin-amd64$R ./$PACK
-fi
+# Checks the collected stderr of a target build for C compiler or linker warnings
+# (any line containing "warning:", as emitted by gcc, clang and the linkers), and
+# runs go vet on the package, failing if either reports anything.
+#
+# Usage: check_warnings <target> <build log> [Go environment variables]
+function check_warnings {
+  local target=$1 log=$2
+  shift 2
+
+  local count=`grep -ci 'warning:' $log`
+  if [ $count -gt 0 ]; then
+    echo "Found $count warning(s) while building $target"
+    return 1
+  fi
+  if ! env "$@" go vet "${T[@]}" ./$PACK; then
+    echo "Found go vet warnings while building $target"
+    return 1
+  fi
+}
+
+# Resolves the NDK clang of an Android target triple at the requested API level.
+#
+# Usage: android_cc <target triple>
+function android_cc {
+  echo $ANDROID_NDK_ROOT/toolchains/llvm/prebuilt/linux-x86_64/bin/$1${ANDROID_API:-21}-clang
+}
+
+# Cross compiles the requested package for a single platform. The C tool-chain
+# of the target is expected in the CC, HOST and PREFIX environment variables.
+#
+# Usage: build_target <target> <GOOS> <GOARCH> [extra Go environment variables]
+function build_target {
+  local target=$1 goos=$2 goarch=$3
+  shift 3
+
+  # Use the target's own build tags and linker flags if any were requested
+  local tags=`target_var FLAG_TAGS $target`
+  if [ "$tags" != "" ]; then local T=(-tags "$tags"); fi
+  local ldflags=`target_var FLAG_LDFLAGS $target`
+  if [ "$ldflags" != "" ]; then local LD=(-ldflags "$ldflags"); fi
+
+  # Override the default C compiler of the target if requested and ensure it exists
+  # (unless CGO is disabled for the target, in which case no C compiler is needed)
+  local cgo=`target_var FLAG_CGO $target`
+  local cc=`target_var CC $target`
+  if [ "$cc" != "" ]; then local -x CC=$cc; fi
+  if [ "${cgo:-1}" != "0" ] && [ "$CC" != "" ] && ! command -v $CC > /dev/null; then
+    echo "C compiler $CC for $target not available in this image"
+    return 1
+  fi
+
+  # Assemble the Go build environment and output name of the target
+  local env=(GOOS=$goos GOARCH=$goarch CGO_ENABLED=${cgo:-1} "$@")
+  if [ "$CC" != "" ]; then env+=(CC=$CC); fi
+  if [ "$FLAG_GOEXPERIMENT" != "" ]; then env+=("GOEXPERIMENT=$FLAG_GOEXPERIMENT"); fi
+  local cxxflags=`target_var CGO_CXXFLAGS $target` cppflags=`target_var CGO_CPPFLAGS $target`
+  if [ "$cxxflags" != "" ]; then env+=("CGO_CXXFLAGS=$cxxflags"); fi
+  if [ "$cppflags" != "" ]; then env+=("CGO_CPPFLAGS=$cppflags"); fi
+
+  # Target the requested minimum macOS release, for the C dependencies too
+  if [ "$goos" == "darwin" ] && [ "$FLAG_MACOSX_MIN" != "" ]; then
+    local -x MACOSX_DEPLOYMENT_TARGET=$FLAG_MACOSX_MIN
+    env+=(MACOSX_DEPLOYMENT_TARGET=$FLAG_MACOSX_MIN)
+  fi
+  local instr out=$NAME-$target
+  if in_list $target "$RACE_TARGETS"; then instr=-race; fi
+  if in_list $target "$MSAN_TARGETS"; then instr=-msan; fi
+  if in_list $target "$ASAN_TARGETS"; then instr=-asan; fi
+  if [ "$goarch" == "amd64" ]; then env+=(GOAMD64=$FLAG_GOAMD64); fi
+  if [ "$goarch" == "386" ]; then env+=(GO386=$FLAG_GO386); fi
+
+  # Apply the user's own build environment last, overriding the defaults above
+  local assign
+  while IFS= read -r assign; do
+    if [ "$assign" != "" ]; then env+=("$assign"); fi
+  done <<< "`target_var FLAG_ENV $target`"
+  out=$out$instr$EXT
+
+  # Append the output extension the host derived from the OS and build mode
+  local mode=`target_var FLAG_BUILDMODE $target` buildmode
+  if [ "$mode" != "" ]; then buildmode=-buildmode=$mode; fi
+  out=$out`target_var FLAG_EXT $target`
+  local name=`target_var FLAG_NAME $target`
+  if [ "$name" != "" ]; then out=$name; fi
+  OUTPUTS+=($BUILD_DIR/$out $BUILD_DIR/.xgo-$out.stamp)
+  if [ "$mode" == "c-archive" ] || [ "$mode" == "c-shared" ]; then OUTPUTS+=($BUILD_DIR/${out%.*}.h); fi
+
+  # Skip the target if its output was built from the exact same inputs
+  local stamp
+  if [ "$SKIP_EXISTING" == "true" ] || [ "$ONLY_CHANGED" == "true" ]; then
+    stamp=`echo "$SOURCE_HASH $MODULE_ROOT/$PACK $out ${env[*]} $GO_CMD $V $instr $buildmode ${T[*]} ${LD[*]} ${A[*]} $FLAG_GODEBUG $FLAG_COMPRESS $SOURCE_DATE_EPOCH" | sha1sum | cut -d ' ' -f 1`
+  fi
+  if [ "$SKIP_EXISTING" == "true" ]; then
+    if [ -f $BUILD_DIR/$out ] && [ "`cat $BUILD_DIR/.xgo-$out.stamp 2> /dev/null`" == "$stamp" ]; then
+      echo "Skipping $goos/$goarch, $out is up to date"
+      echo "skipped $target up to date" >> $REPORT
+      return 0
+    fi
+  fi
+  # Skip the target if its inputs (and the image) match the previous build's
+  if [ "$ONLY_CHANGED" == "true" ]; then
+    local inputs=`echo "$stamp $IMAGE_ID" | sha1sum | cut -d ' ' -f 1`
+    echo "inputs $target $inputs" >> $REPORT
+    if [ "$inputs" == "`target_var PREV_INPUTS $target`" ]; then
+      echo "Skipping $goos/$goarch, $out is up to date"
+      echo "unchanged $target" >> $REPORT
+      return 0
+    fi
+  fi
+  if [ "$NO_CLOBBER" == "true" ] && [ -e $BUILD_DIR/$out ]; then
+    echo "Refusing to overwrite existing $out of $target"
+    return 1
+  fi
+  echo "Compiling for $goos/$goarch..."
+  local start=$SECONDS
+  if [ "${cgo:-1}" == "0" ]; then
+    echo "CGO disabled for $target, skipping the C dependencies"
+  else
+    HOST=$HOST PREFIX=$PREFIX $BUILD_DEPS /deps || return 1
+  fi
+
+  # Explicitly propagate failures, errexit is ignored when called with KEEP_GOING
+  env "${env[@]}" go get -d $GET_T "${T[@]}" ./$PACK || return 1
+  if [ "$FAIL_ON_WARNING" == "true" ]; then
+    env "${env[@]}" go $GO_CMD $V $instr $buildmode "${T[@]}" "${LD[@]}" "${A[@]}" -o $BUILD_DIR/$out ./$PACK 2> /tmp/xgo-$target.log
+    local status=$?
+    cat /tmp/xgo-$target.log >&2
+    if [ $status -ne 0 ]; then return 1; fi
+    check_warnings $target /tmp/xgo-$target.log "${env[@]}" || return 1
+  else
+    env "${env[@]}" go $GO_CMD $V $instr $buildmode "${T[@]}" "${LD[@]}" "${A[@]}" -o $BUILD_DIR/$out ./$PACK || return 1
+  fi
+
+  # Compress the binary if requested and the target is supported by UPX
+  if [ "$FLAG_COMPRESS" == "true" ]; then
+    if [ "$goos" == "darwin" ] || [ "$goos" == "ios" ]; then
+      echo "Skipping compression of $out, UPX does not support $goos binaries"
+    elif [ "$mode" == "c-shared" ] || [ "$mode" == "c-archive" ]; then
+      echo "Skipping compression of $out, only executables are compressed"
+    else
+      local size=`stat -c %s $BUILD_DIR/$out`
+      upx -q --best $BUILD_DIR/$out > /dev/null || return 1
+      echo "Compressed $out from $size to `stat -c %s $BUILD_DIR/$out` bytes"
+    fi
+  fi
+  if [ "$SKIP_EXISTING" == "true" ]; then echo $stamp > $BUILD_DIR/.xgo-$out.stamp; fi
+
+  echo "Finished $goos/$goarch in $((SECONDS - start))s"
+  echo "built $target $out $((SECONDS - start))" >> $REPORT
+}
+
+# Build for each platform individually
+for target in ${TARGETS//,/ }; do
+  case $target in
+    linux-amd64)
+      HOST=x86_64-linux PREFIX=/usr/local build_target $target linux amd64 ;;
+    linux-386)
+      HOST=i686-linux PREFIX=/usr/local build_target $target linux 386 ;;
+    linux-arm)
+      CC=arm-linux-gnueabi-gcc HOST=arm-linux PREFIX=/usr/local/arm build_target $target linux arm GOARM=5 ;;
+    windows-amd64)
+      CC=x86_64-w64-mingw32-gcc HOST=x86_64-w64-mingw32 PREFIX=/usr/x86_64-w64-mingw32 build_target $target windows amd64 ;;
+    windows-386)
+      CC=i686-w64-mingw32-gcc HOST=i686-w64-mingw32 PREFIX=/usr/i686-w64-mingw32 build_target $target windows 386 ;;
+    darwin-amd64)
+      CC=o64-clang HOST=x86_64-apple-darwin10 PREFIX=/usr/local build_target $target darwin amd64 ;;
+    darwin-386)
+      CC=o32-clang HOST=i386-apple-darwin10 PREFIX=/usr/local build_target $target darwin 386 ;;
+    android-arm)
+      CC=`android_cc armv7a-linux-androideabi` HOST=arm-linux-androideabi PREFIX=/usr/local/android-arm build_target $target android arm GOARM=7 ;;
+    android-arm64)
+      CC=`android_cc aarch64-linux-android` HOST=aarch64-linux-android PREFIX=/usr/local/android-arm64 build_target $target android arm64 ;;
+    android-amd64)
+      CC=`android_cc x86_64-linux-android` HOST=x86_64-linux-android PREFIX=/usr/local/android-amd64 build_target $target android amd64 ;;
+    android-386)
+      CC=`android_cc i686-linux-android` HOST=i686-linux-android PREFIX=/usr/local/android-386 build_target $target android 386 ;;
+    ios-arm64)
+      CC=arm64-apple-ios-clang HOST=arm-apple-darwin11 PREFIX=/usr/local/ios-arm64 build_target $target ios arm64 ;;
+    ios-arm64-simulator)
+      CC=arm64-apple-ios-simulator-clang HOST=arm-apple-darwin11 PREFIX=/usr/local/ios-arm64-simulator build_target $target ios arm64 ;;
+    ios-amd64-simulator)
+      CC=x86_64-apple-ios-simulator-clang HOST=x86_64-apple-darwin11 PREFIX=/usr/local/ios-amd64-simulator build_target $target ios amd64 ;;
+    linux-riscv64)
+      CC=riscv64-linux-gnu-gcc HOST=riscv64-linux-gnu PREFIX=/usr/local/riscv64 build_target $target linux riscv64 ;;
+    linux-loong64)
+      CC=loongarch64-linux-gnu-gcc HOST=loongarch64-linux-gnu PREFIX=/usr/local/loong64 build_target $target linux loong64 ;;
+    freebsd-arm64)
+      CC=aarch64-unknown-freebsd-clang HOST=aarch64-unknown-freebsd PREFIX=/usr/local/freebsd-arm64 build_target $target freebsd arm64 ;;
+    linux-amd64-musl)
+      CC=x86_64-linux-musl-gcc HOST=x86_64-linux-musl PREFIX=/usr/local/musl-amd64 build_target $target linux amd64 ;;
+    linux-arm64-musl)
+      CC=aarch64-linux-musl-gcc HOST=aarch64-linux-musl PREFIX=/usr/local/musl-arm64 build_target $target linux arm64 ;;
+    *)
+      echo "Unknown target $target, skipping..."
+      echo "skipped $target unknown to its build script" >> $REPORT ;;
+  esac || {
+    if [ "$KEEP_GOING" != "true" ]; then exit 1; fi
+    echo "Failed to build $target, continuing with the remaining targets..."
+    FAILED+=($target)
+  }
+done
 
-if [ "${DARWIN386}" = "true" ];then
-    echo "Compiling for darwin/386..."
-    CC=o32-clang HOST=i386-apple-darwin10 PREFIX=/usr/local $BUILD_DEPS /deps
-    CC=o32-clang GOOS=darwin GOARCH=386 CGO_ENABLED=1 go get -d ./$PACK
-    CC=o32-clang GOOS=darwin GOARCH=386 CGO_ENABLED=1 go build $V -o $NAME-darwin-386 ./$PACK
+# Report all the failed targets if the build was allowed to keep going
+if [ ${#FAILED[@]} -gt 0 ]; then
+  echo "Failed to build ${#FAILED[@]} target(s): ${FAILED[*]}"
+  exit 1
 fi
-echo "Moving binaries to host..."
-cp `ls -t | head -n 7` /build
@@ -0,0 +1,85 @@
+#!/bin/bash
+#
+# Contains a diagnostic reporter printing the capabilities of the image: the Go
+# release, the C compiler of each target the build script knows and whether it's
+# available, and the GOOS/GOARCH pairs the Go toolchain itself supports.
+#
+# Usage: info.sh
+#
+# Printed lines, each having a type and space separated fields:
+#   go <version>
+#   target <target> <C compiler> <available|missing>
+#   darwin-sdk <SDK>
+#   linker <name> <target> <available|missing>
+#   platform <GOOS>/<GOARCH>
+
+echo "go `go version | awk '{print $3}'`"
+
+# Reports a single target along with the availability of its C compiler.
+#
+# Usage: report_target <target> <C compiler>
+function report_target {
+  if command -v $2 > /dev/null; then
+    echo "target $1 $2 available"
+  else
+    echo "target $1 $2 missing"
+  fi
+}
+
+report_target linux-amd64 gcc
+report_target linux-386 gcc
+report_target linux-arm arm-linux-gnueabi-gcc
+report_target windows-amd64 x86_64-w64-mingw32-gcc
+report_target windows-386 i686-w64-mingw32-gcc
+report_target darwin-amd64 o64-clang
+report_target darwin-386 o32-clang
+
+# Report the macOS SDK the darwin toolchain was built against
+if [ "$OSX_SDK" != "" ]; then
+  echo "darwin-sdk $OSX_SDK"
+fi
+
+# Reports a selectable external linker (-fuse-ld=<name>) of a target's C toolchain
+# along with its availability, checking the binary of the target's own (cross)
+# binutils rather than the host's.
+#
+# Usage: report_linker <name> <target> <linker binary>
+function report_linker {
+  if command -v $3 > /dev/null; then
+    echo "linker $1 $2 available"
+  else
+    echo "linker $1 $2 missing"
+  fi
+}
+
+report_linker gold linux-amd64 ld.gold
+report_linker gold linux-386 ld.gold
+report_linker gold linux-arm arm-linux-gnueabi-ld.gold
+for target in linux-amd64 linux-386 linux-arm linux-riscv64 linux-loong64 linux-amd64-musl linux-arm64-musl freebsd-arm64; do
+  report_linker lld $target ld.lld
+done
+
+NDK_BIN=$ANDROID_NDK_ROOT/toolchains/llvm/prebuilt/linux-x86_64/bin
+report_target android-arm $NDK_BIN/armv7a-linux-androideabi${ANDROID_API:-21}-clang
+report_target android-arm64 $NDK_BIN/aarch64-linux-android${ANDROID_API:-21}-clang
+report_target android-amd64 $NDK_BIN/x86_64-linux-android${ANDROID_API:-21}-clang
+report_target android-386 $NDK_BIN/i686-linux-android${ANDROID_API:-21}-clang
+for target in android-arm android-arm64 android-amd64 android-386; do
+  report_linker lld $target $NDK_BIN/ld.lld
+done
+
+report_target ios-arm64 arm64-apple-ios-clang
+report_target ios-arm64-simulator arm64-apple-ios-simulator-clang
+report_target ios-amd64-simulator x86_64-apple-ios-simulator-clang
+
+report_target linux-riscv64 riscv64-linux-gnu-gcc
+report_target linux-loong64 loongarch64-linux-gnu-gcc
+report_target freebsd-arm64 aarch64-unknown-freebsd-clang
+
+report_target linux-amd64-musl x86_64-linux-musl-gcc
+report_target linux-arm64-musl aarch64-linux-musl-gcc
+
+# List the platforms of the Go toolchain (go tool dist list needs Go 1.7+)
+for platform in `go tool dist list 2> /dev/null`; do
+  echo "platform $platform"
+done
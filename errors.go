@@ -0,0 +1,84 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Failure classes of xgo and the exit codes they map to.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Classes of failures, each exiting with a distinct code so scripts can tell them
+// apart (see exitCode).
+var (
+	ErrSystem = errors.New("system failure") // Docker, network or filesystem failed (exit 1)
+	ErrUsage  = errors.New("invalid usage")  // Bad flags or arguments were given (exit 2)
+	ErrBuild  = errors.New("build failure")  // The cross compilation itself failed (exit 3)
+)
+
+// Error is a failure of xgo tagged with its class, matching it via errors.Is.
+type Error struct {
+	Kind error // Class of the failure (ErrSystem, ErrUsage or ErrBuild)
+	Err  error // Underlying cause of the failure
+}
+
+func (e *Error) Error() string        { return e.Err.Error() }
+func (e *Error) Unwrap() error        { return e.Err }
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+// Tags a build failure, so it's reported with the build exit code.
+func buildError(err error) error {
+	return &Error{Kind: ErrBuild, Err: err}
+}
+
+// Returns the class of a failure, anything not explicitly tagged being a system one.
+func failureKind(err error) error {
+	for _, kind := range []error{ErrUsage, ErrBuild} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return ErrSystem
+}
+
+// Returns the process exit code of a failure class.
+func exitCode(err error) int {
+	switch failureKind(err) {
+	case ErrUsage:
+		return 2
+	case ErrBuild:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// Cleanups to run whenever xgo exits, as the os.Exit of fatalf skips deferred calls.
+var exitHooks []func()
+
+// Registers a cleanup to run on exit, be it a fatal error or a regular return.
+func atExit(hook func()) {
+	exitHooks = append(exitHooks, hook)
+}
+
+// Runs the registered exit cleanups in reverse order, each at most once.
+func runExitHooks() {
+	hooks := exitHooks
+	exitHooks = nil
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+}
+
+// Reports a fatal error, runs the exit cleanups and exits with the exit code of
+// its class.
+func fatalf(kind error, format string, args ...interface{}) {
+	log.Print(fmt.Sprintf(format, args...))
+	runExitHooks()
+	os.Exit(exitCode(kind))
+}
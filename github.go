@@ -0,0 +1,134 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// GitHub Actions workflow command formatting of the container's build logs.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Matcher for compiler diagnostics of the go tool, gcc and clang, capturing the
+// file, line, optional column, optional severity and the message itself.
+var diagnosticRe = regexp.MustCompile(`^([^\s:]+\.(?:go|c|h|cc|cpp|m|s|S)):(\d+)(?::(\d+))?: (?:(warning|error|fatal error): )?(.+)$`)
+
+// githubWriter is an output filter that turns the container's build logs into
+// GitHub Actions workflow commands: each target is folded into its own log group
+// and compiler diagnostics and failures are surfaced as annotations.
+type githubWriter struct {
+	out    io.Writer // Stream to write the filtered logs into
+	repo   string    // Path of the repository within the container
+	root   string    // Repository relative folder the go tool runs in
+	buffer []byte    // Partial line not yet terminated
+	group  bool      // Whether a log group is currently open
+}
+
+// Creates an output filter for the build logs of a repository.
+func newGithubWriter(out io.Writer, config *ConfigFlags) *githubWriter {
+	return &githubWriter{
+		out:  out,
+		repo: "/go/src/" + config.Repository + "/",
+		root: config.ModuleRoot,
+	}
+}
+
+func (w *githubWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		end := bytes.IndexByte(w.buffer, '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		line := string(bytes.TrimRight(w.buffer[:end], "\r"))
+		w.buffer = w.buffer[end+1:]
+
+		if err := w.line(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Flushes any unterminated line and closes the open log group, if any.
+func (w *githubWriter) Close() error {
+	if len(w.buffer) > 0 {
+		if err := w.line(string(w.buffer)); err != nil {
+			return err
+		}
+		w.buffer = nil
+	}
+	if w.group {
+		w.group = false
+		_, err := fmt.Fprintln(w.out, "::endgroup::")
+		return err
+	}
+	return nil
+}
+
+// Formats a single log line, wrapping it into workflow commands as needed.
+func (w *githubWriter) line(line string) error {
+	var err error
+	switch {
+	case strings.HasPrefix(line, "Compiling for "):
+		if w.group {
+			fmt.Fprintln(w.out, "::endgroup::")
+		}
+		w.group = true
+		_, err = fmt.Fprintf(w.out, "::group::%s\n", githubEscape(line))
+
+	case strings.HasPrefix(line, "Failed to build ") || strings.HasSuffix(line, "not available in this image"):
+		_, err = fmt.Fprintf(w.out, "%s\n::error::%s\n", line, githubEscape(line))
+
+	case diagnosticRe.MatchString(line):
+		match := diagnosticRe.FindStringSubmatch(line)
+
+		level := "error"
+		if match[4] == "warning" {
+			level = "warning"
+		}
+		command := level
+		if file := w.file(match[1]); file != "" {
+			command += " file=" + githubEscapeProperty(file) + ",line=" + match[2]
+			if match[3] != "" {
+				command += ",col=" + match[3]
+			}
+		}
+		_, err = fmt.Fprintf(w.out, "%s\n::%s::%s\n", line, command, githubEscape(match[5]))
+
+	default:
+		_, err = fmt.Fprintln(w.out, line)
+	}
+	if err == nil && w.group && strings.HasPrefix(line, "Finished ") {
+		w.group = false
+		_, err = fmt.Fprintln(w.out, "::endgroup::")
+	}
+	return err
+}
+
+// Converts a file path reported inside the container into a repository relative
+// one, or an empty string if the file is outside of the repository.
+func (w *githubWriter) file(name string) string {
+	if strings.HasPrefix(name, "/") {
+		if !strings.HasPrefix(name, w.repo) {
+			return ""
+		}
+		return strings.TrimPrefix(name, w.repo)
+	}
+	return path.Join(w.root, name)
+}
+
+// Escapes the data of a workflow command.
+func githubEscape(data string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(data)
+}
+
+// Escapes a property value of a workflow command.
+func githubEscapeProperty(value string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(value)
+}
@@ -0,0 +1,54 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Host side hook commands run on the produced artifacts.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Runs the post build hook command once for every produced artifact, passing the
+// artifact's path and target both as trailing arguments and via the environment.
+// All artifacts are processed even if some hooks fail, the failures being returned.
+func runPostBuildHooks(command string, folder string, artifacts []string, report *BuildReport) error {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil
+	}
+	var failed []string
+	for _, name := range artifacts {
+		path := filepath.Join(folder, name)
+
+		var target, goos, goarch string
+		if output, ok := report.Outputs[name]; ok {
+			target = output.Target
+			if known := findTarget(target); known != nil {
+				goos, goarch = known.OS, known.Arch
+			}
+		}
+		cmd := runner.Command(fields[0], append(fields[1:], path, target)...)
+		cmd.Env = append(os.Environ(),
+			"XGO_ARTIFACT="+path,
+			"XGO_ARTIFACT_NAME="+name,
+			"XGO_TARGET="+target,
+			"XGO_GOOS="+goos,
+			"XGO_GOARCH="+goarch,
+			"XGO_OUTPUT_DIR="+folder,
+		)
+		fmt.Fprintf(infoOutput, "Running post build hook on %s...\n", name)
+		if err := run(cmd); err != nil {
+			warnf("Post build hook failed on %s: %v.", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("hook failed on %d artifact(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Image capability inspection via the container's diagnostic entrypoint.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Path of the diagnostic entrypoint inside the xgo images.
+const infoScript = "/info.sh"
+
+// ImageInfo is the capability report of an xgo image.
+type ImageInfo struct {
+	GoVersion string        `json:"go_version"` // Go release provided by the image
+	Targets   []*TargetInfo `json:"targets"`    // Targets known by the image's build script
+	DarwinSDK string        `json:"darwin_sdk"` // macOS SDK the darwin targets are built against
+	Linkers   []string      `json:"linkers"`    // Selectable external linkers available (gold, lld)
+	Platforms []string      `json:"platforms"`  // GOOS/GOARCH pairs supported by the Go toolchain
+
+	LinkerTargets map[string][]string `json:"linker_targets,omitempty"` // Targets each linker is available for (nil = not reported per target)
+}
+
+// TargetInfo is the availability of a single target within an xgo image.
+type TargetInfo struct {
+	Name      string `json:"name"`      // Name of the target (e.g. linux-arm)
+	Compiler  string `json:"compiler"`  // C compiler the target is built with
+	Available bool   `json:"available"` // Whether the C compiler exists in the image
+}
+
+// Runs the diagnostic entrypoint of an image and parses its capability report.
+//
+// The report is line based, each line having a type and space separated fields:
+//
+//	go <version>
+//	target <target> <C compiler> <available|missing>
+//	darwin-sdk <SDK>
+//	linker <name> <target> <available|missing>
+//	platform <GOOS>/<GOARCH>
+func inspectImage(image string) (*ImageInfo, error) {
+	out, err := runner.Output(dockerCommand("run", "--rm", "--entrypoint", infoScript, image))
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+			err = fmt.Errorf("%v: %s", err, bytes.TrimSpace(exit.Stderr))
+		}
+		return nil, fmt.Errorf("%v (image lacks %s?)", err, infoScript)
+	}
+	info := &ImageInfo{Targets: []*TargetInfo{}, Linkers: []string{}, Platforms: []string{}}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "go":
+			info.GoVersion = fields[1]
+		case len(fields) == 4 && fields[0] == "target":
+			info.Targets = append(info.Targets, &TargetInfo{Name: fields[1], Compiler: fields[2], Available: fields[3] == "available"})
+		case len(fields) == 2 && fields[0] == "darwin-sdk":
+			info.DarwinSDK = fields[1]
+		case len(fields) == 3 && fields[0] == "linker":
+			// Older images only report the linkers by name, not per target
+			if fields[2] == "available" && !stringInSlice(fields[1], info.Linkers) {
+				info.Linkers = append(info.Linkers, fields[1])
+			}
+		case len(fields) == 4 && fields[0] == "linker":
+			if info.LinkerTargets == nil {
+				info.LinkerTargets = make(map[string][]string)
+			}
+			if fields[3] == "available" {
+				if !stringInSlice(fields[1], info.Linkers) {
+					info.Linkers = append(info.Linkers, fields[1])
+				}
+				info.LinkerTargets[fields[1]] = append(info.LinkerTargets[fields[1]], fields[2])
+			}
+		case len(fields) == 2 && fields[0] == "platform":
+			info.Platforms = append(info.Platforms, fields[1])
+		}
+	}
+	return info, scanner.Err()
+}
+
+// Prints the capability report of an image, either human readable into the
+// logs, or as JSON onto stdout in JSON mode.
+func printImageInfo(image string, info *ImageInfo) error {
+	if *jsonOutput {
+		blob, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", blob)
+		return err
+	}
+	fmt.Fprintf(logOutput, "\nImage:      %s\n", image)
+	fmt.Fprintf(logOutput, "Go release: %s\n", info.GoVersion)
+	if info.DarwinSDK != "" {
+		fmt.Fprintf(logOutput, "Darwin SDK: %s\n", info.DarwinSDK)
+	}
+	if len(info.Linkers) > 0 {
+		linkers := make([]string, len(info.Linkers))
+		for i, linker := range info.Linkers {
+			linkers[i] = linker
+			if targets, ok := info.LinkerTargets[linker]; ok {
+				linkers[i] += " (" + strings.Join(targets, ", ") + ")"
+			}
+		}
+		fmt.Fprintf(logOutput, "Linkers:    %s\n", strings.Join(linkers, ", "))
+	}
+
+	fmt.Fprintf(logOutput, "\nTargets:\n")
+	for _, target := range info.Targets {
+		status := "available"
+		if !target.Available {
+			status = "missing toolchain"
+		}
+		fmt.Fprintf(logOutput, "  %-16s %-28s %s\n", target.Name, target.Compiler, status)
+	}
+	if len(info.Platforms) > 0 {
+		fmt.Fprintf(logOutput, "\nGo platforms (%d):\n", len(info.Platforms))
+		for i := 0; i < len(info.Platforms); i += 6 {
+			end := i + 6
+			if end > len(info.Platforms) {
+				end = len(info.Platforms)
+			}
+			fmt.Fprintf(logOutput, "  %s\n", strings.Join(info.Platforms[i:end], "  "))
+		}
+	}
+	return nil
+}
+
+// Returns the targets the requested external linker applies to, but which the image
+// lacks it for. Images reporting the linkers by name only are trusted for all targets.
+func missingLinkerTargets(info *ImageInfo, flags *BuildFlags, targets []*Target) []string {
+	var missing []string
+	for _, target := range targets {
+		if !linkerApplies(flags, target) {
+			continue
+		}
+		if info.LinkerTargets != nil {
+			if !stringInSlice(target.Name, info.LinkerTargets[flags.Linker]) {
+				missing = append(missing, target.Name)
+			}
+		} else if !stringInSlice(flags.Linker, info.Linkers) {
+			missing = append(missing, target.Name)
+		}
+	}
+	return missing
+}
+
+// Filters the selected targets down to the ones the image can build, also returning
+// the dropped ones along with the reasons. Targets whose platform the image's Go
+// release doesn't know are always dropped. Targets built with a custom C compiler or
+// with CGO disabled don't need the image's default toolchain, so they are kept, and
+// the pure Go capable ones lacking it get CGO disabled (with a warning) instead.
+func supportedTargets(info *ImageInfo, targets []*Target, flags *BuildFlags) ([]*Target, []*SkippedTarget) {
+	available := make(map[string]*TargetInfo)
+	for _, target := range info.Targets {
+		available[target.Name] = target
+	}
+	var (
+		supported   []*Target
+		unsupported []*SkippedTarget
+	)
+	for _, target := range targets {
+		if len(info.Platforms) > 0 && !stringInSlice(target.Platform(), info.Platforms) {
+			unsupported = append(unsupported, &SkippedTarget{Target: target.Name, Reason: "unknown to Go " + info.GoVersion})
+			continue
+		}
+		if flags.CC.Value(target.Name) != "" || !cgoEnabled(flags, target) {
+			supported = append(supported, target)
+			continue
+		}
+		known, ok := available[target.Name]
+		switch {
+		case ok && known.Available:
+			supported = append(supported, target)
+		case target.PureGo:
+			warnf("Image lacks a C toolchain for %s, building it as pure Go (CGO disabled).", target.Name)
+			flags.Cgo.Overrides[target.Name] = "false"
+			supported = append(supported, target)
+		case !ok:
+			unsupported = append(unsupported, &SkippedTarget{Target: target.Name, Reason: "unknown to its build script"})
+		default:
+			unsupported = append(unsupported, &SkippedTarget{Target: target.Name, Reason: "C compiler " + known.Compiler + " missing"})
+		}
+	}
+	return supported, unsupported
+}
@@ -0,0 +1,52 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Tests of the image capability reports.
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Tests that the linkers reported per target (or by name only, by older images)
+// are checked against the selected targets.
+func TestMissingLinkerTargets(t *testing.T) {
+	perTarget := "go go1.22.0\n" +
+		"linker gold linux-amd64 available\n" +
+		"linker gold linux-386 available\n" +
+		"linker gold linux-arm missing\n" +
+		"linker lld linux-amd64 missing\n"
+
+	tests := []struct {
+		report  string   // Capability report of the image
+		linker  string   // Requested external linker
+		targets string   // Selected targets
+		missing []string // Expected targets lacking the linker
+	}{
+		{report: perTarget, linker: "gold", targets: "linux-amd64,linux-386", missing: nil},
+		{report: perTarget, linker: "gold", targets: "linux-amd64,linux-arm", missing: []string{"linux-arm"}},
+		{report: perTarget, linker: "gold", targets: "linux-riscv64,windows-amd64", missing: nil},
+		{report: perTarget, linker: "lld", targets: "linux-amd64,android-arm64", missing: []string{"linux-amd64", "android-arm64"}},
+		{report: "linker gold available\nlinker lld missing\n", linker: "gold", targets: "linux-amd64,linux-arm", missing: nil},
+		{report: "linker gold available\nlinker lld missing\n", linker: "lld", targets: "linux-amd64", missing: []string{"linux-amd64"}},
+		{report: "go go1.22.0\n", linker: "gold", targets: "linux-amd64", missing: []string{"linux-amd64"}},
+	}
+	for i, tt := range tests {
+		fake := &fakeRunner{respond: func(args []string) (string, int, string) { return tt.report, 0, "" }}
+		useFakeRunner(t, fake, "24.0.7")
+
+		info, err := inspectImage("karalabe/xgo-latest")
+		if err != nil {
+			t.Fatalf("test %d: failed to inspect image: %v", i, err)
+		}
+		flags := defaultBuildFlags()
+		flags.Linker = tt.linker
+		targets, _ := getTargets(tt.targets)
+		if missing := missingLinkerTargets(info, flags, targets); !reflect.DeepEqual(missing, tt.missing) {
+			t.Errorf("test %d: missing targets mismatch: have %v, want %v", i, missing, tt.missing)
+		}
+	}
+}
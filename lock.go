@@ -0,0 +1,63 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Output folder locking against concurrent builds clobbering each other, and image
+// pull slots bounding the concurrent pulls of parallel xgo invocations.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the lock file guarding an output folder against concurrent builds.
+const outputLockFile = ".xgo.lock"
+
+// Interval at which a locked output folder is rechecked when waiting for it.
+const lockRetryInterval = time.Second
+
+// errLocked is returned if the output folder is locked by another build.
+var errLocked = errors.New("output folder is locked by another xgo build")
+
+// Locks an output folder for the exclusive use of this build, either failing fast
+// or waiting if another build is holding it. The returned function releases it.
+func lockOutput(folder string, wait bool) (func(), error) {
+	path := filepath.Join(folder, outputLockFile)
+	for waiting := false; ; waiting = true {
+		unlock, err := tryLockFile(path)
+		if err != errLocked || !wait {
+			return unlock, err
+		}
+		if !waiting {
+			fmt.Fprintf(infoOutput, "Waiting for another build to release %s...\n", folder)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Acquires one of the given number of image pull slots, shared between all the xgo
+// invocations on the host (e.g. parallel CI jobs) via lock files in the temporary
+// folder, waiting until one frees up. The returned function releases the slot. A
+// non-positive limit means unbounded pulls.
+func acquirePullSlot(limit int) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	for waiting := false; ; waiting = true {
+		for i := 0; i < limit; i++ {
+			unlock, err := tryLockFile(filepath.Join(os.TempDir(), fmt.Sprintf("xgo-pull-%d.lock", i)))
+			if err != errLocked {
+				return unlock, err
+			}
+		}
+		if !waiting {
+			fmt.Fprintf(infoOutput, "Waiting for one of the %d image pull slots to free up...\n", limit)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
@@ -0,0 +1,30 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+// Output folder locking via flock, released by the kernel even if xgo crashes.
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Tries to acquire an exclusive flock on the lock file without blocking.
+func tryLockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return func() { file.Close() }, nil
+}
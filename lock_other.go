@@ -0,0 +1,31 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+// Output folder locking via exclusively created lock files, for platforms without
+// flock. A lock file left behind by a crashed build is reclaimed if it's not held
+// open anymore (on Windows open files cannot be deleted).
+package main
+
+import "os"
+
+// Tries to exclusively create the lock file, reclaiming any stale one first.
+func tryLockFile(path string) (func(), error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errLocked
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return func() {
+		file.Close()
+		os.Remove(path)
+	}, nil
+}
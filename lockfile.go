@@ -0,0 +1,134 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Build lockfiles capturing the fully resolved inputs of a build for exact rebuilds.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Version identifier of the lockfile schema, bumped on incompatible changes.
+const lockfileSchema = "xgo-lock/v1"
+
+// Flags not recorded into lockfiles, as they either control the lockfiles themselves
+// or are recorded in resolved form (the Go release, the targets of a targets file).
+var unlockedFlags = map[string]bool{"write-lock": true, "from-lock": true, "go": true, "targets-file": true}
+
+// Lockfile is the fully resolved set of inputs of a build, allowing to rebuild it
+// exactly. The flags are applied over the command line ones on rebuilds, the Go
+// release and revision are pinned, and the image and dependencies are verified.
+type Lockfile struct {
+	Schema       string            `json:"schema"`             // Schema version of the document
+	GoRelease    string            `json:"go_release"`         // Resolved Go release of the image (-go)
+	GoVersion    string            `json:"go_version"`         // Go release reported by the container
+	Image        string            `json:"image"`              // Docker image reference used for the build
+	ImageID      string            `json:"image_id"`           // Content digest of the docker image used
+	Repository   string            `json:"repository"`         // Root import path that was built
+	Revision     string            `json:"revision,omitempty"` // Version control revision that was checked out
+	Dependencies []*Dependency     `json:"dependencies"`       // CGO dependency archives with their checksums
+	Flags        map[string]string `json:"flags"`              // Command line flags explicitly set on xgo
+	Args         []string          `json:"args,omitempty"`     // Extra arguments passed verbatim to go build
+}
+
+// Assembles the lockfile of a finished build and writes it as JSON into the
+// requested file.
+func writeLockfile(path string, image string, config *ConfigFlags, extra []string, report *BuildReport) error {
+	digest, err := inspectDockerImage(image)
+	if err != nil {
+		return err
+	}
+	lock := &Lockfile{
+		Schema:       lockfileSchema,
+		GoRelease:    *goVersion,
+		GoVersion:    report.GoVersion,
+		Image:        image,
+		ImageID:      digest,
+		Repository:   config.Repository,
+		Revision:     report.Revision,
+		Dependencies: report.Dependencies,
+		Flags:        make(map[string]string),
+		Args:         extra,
+	}
+	if lock.Dependencies == nil {
+		lock.Dependencies = []*Dependency{}
+	}
+	for name, value := range explicitFlags() {
+		if !unlockedFlags[name] {
+			lock.Flags[name] = value
+		}
+	}
+	blob, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(blob, '\n'), 0644)
+}
+
+// Reads a lockfile, rejecting unknown schema versions.
+func readLockfile(path string) (*Lockfile, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := new(Lockfile)
+	if err := json.Unmarshal(blob, lock); err != nil {
+		return nil, err
+	}
+	if lock.Schema != lockfileSchema {
+		return nil, fmt.Errorf("unsupported schema %q (expected %s)", lock.Schema, lockfileSchema)
+	}
+	return lock, nil
+}
+
+// Applies the flags of a lockfile over the command line ones, pins the Go release,
+// and checks out the locked revision (unless building local sources as is).
+func applyLockfile(lock *Lockfile) error {
+	for name, value := range lock.Flags {
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown flag -%s", name)
+		}
+		// Accumulating flags would merge with the command line, reset them first
+		switch v := f.Value.(type) {
+		case *targetFlag:
+			*v = targetFlag{Overrides: make(map[string]string)}
+		case *stringsFlag:
+			*v = nil
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("-%s: %v", name, err)
+		}
+	}
+	if _, pinned := lock.Flags["image-tag"]; !pinned && lock.GoRelease != "" {
+		flag.Set("go", lock.GoRelease)
+	}
+	if _, local := lock.Flags["local"]; !local && lock.Revision != "" {
+		flag.Set("branch", lock.Revision)
+	}
+	return nil
+}
+
+// Returns the expected checksums of the requested dependencies as known by the
+// lockfile, space separated in the same order with - for unknown ones.
+func lockedChecksums(lock *Lockfile, deps string) string {
+	known := make(map[string]string)
+	for _, dep := range lock.Dependencies {
+		known[dep.URL] = dep.SHA256
+	}
+	var sums []string
+	for _, dep := range strings.Fields(deps) {
+		if sum, ok := known[dep]; ok {
+			sums = append(sums, sum)
+		} else {
+			sums = append(sums, "-")
+		}
+	}
+	return strings.Join(sums, " ")
+}
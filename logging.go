@@ -0,0 +1,160 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Verbosity control and terminal colors of xgo's own host side messages.
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Verbosity levels of xgo's own messages, each including the ones before it.
+const (
+	levelError = iota // Only errors are reported
+	levelWarn         // Warnings about suspicious or ignored settings are reported too
+	levelInfo         // Progress messages are reported too (default)
+	levelDebug        // Docker invocations are traced too
+)
+
+// Names of the verbosity levels, as accepted by -log-level.
+var logLevels = map[string]int{
+	"error": levelError,
+	"warn":  levelWarn,
+	"info":  levelInfo,
+	"debug": levelDebug,
+}
+
+// Verbosity level of xgo's own messages, set from the command line.
+var verbosity = levelInfo
+
+// Destination of xgo's own progress messages, discarded below the info level.
+var infoOutput io.Writer = logOutput
+
+// Copy of the output of all the docker commands run, if saved via -log-file.
+var logSink io.Writer
+
+// File info of the -log-file, so it's not mistaken for a build output if it's
+// written into the output folder.
+var logFileInfo os.FileInfo
+
+// Loggers of the warnings and debug traces, separate from the standard logger (used
+// for errors) so they can be colored differently.
+var (
+	warnLog  = log.New(os.Stderr, "", log.LstdFlags)
+	debugLog = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// ANSI escape sequences of the colors used on terminals.
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// Whether the progress messages are colored, set if their destination is a terminal.
+var colorful bool
+
+// Configures the verbosity of xgo's own messages from a level name.
+func setLogLevel(name string) error {
+	level, ok := logLevels[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown level %s (must be error, warn, info or debug)", name)
+	}
+	verbosity, infoOutput = level, logOutput
+	if level < levelInfo {
+		infoOutput = io.Discard
+	}
+	return nil
+}
+
+// Reports a warning, unless below the warning verbosity level.
+func warnf(format string, args ...interface{}) {
+	if verbosity >= levelWarn {
+		warnLog.Printf(format, args...)
+	}
+}
+
+// Reports a debug trace, only at the debug verbosity level.
+func debugf(format string, args ...interface{}) {
+	if verbosity >= levelDebug {
+		debugLog.Printf(format, args...)
+	}
+}
+
+// Creates (or truncates) the file the docker output is copied into, starting it
+// with a header recording when and how xgo was invoked.
+func openLogFile(path string) (*os.File, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(file, "# xgo build log, started %s\n# %s\n\n", time.Now().Format(time.RFC3339), strings.Join(os.Args, " ")); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if logFileInfo, err = file.Stat(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Colors xgo's own messages on the terminals among their destinations: progress
+// cyan, warnings yellow and errors red. The container's output is left intact. Must
+// be called after the verbosity is set.
+func setColors() {
+	if isTerminal(logOutput) && verbosity >= levelInfo {
+		colorful, infoOutput = true, &colorWriter{out: logOutput, color: colorCyan}
+	}
+	if isTerminal(os.Stderr) {
+		log.SetOutput(&colorWriter{out: os.Stderr, color: colorRed})
+		warnLog.SetOutput(&colorWriter{out: os.Stderr, color: colorYellow})
+	}
+}
+
+// Checks whether a writer is a terminal (character device), as opposed to a pipe
+// or a file.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Wraps a progress message into a color, overriding the default progress one, if
+// the progress messages are colored at all.
+func paint(color string, text string) string {
+	if !colorful {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// colorWriter is a writer wrapping everything written through it into a color,
+// keeping the trailing newlines outside so the color doesn't bleed into the next line.
+type colorWriter struct {
+	out   io.Writer // Destination to write the colored text into
+	color string    // ANSI escape sequence of the color to use
+}
+
+func (w *colorWriter) Write(p []byte) (int, error) {
+	text := strings.TrimRight(string(p), "\n")
+	if text == "" {
+		return w.out.Write(p)
+	}
+	if _, err := io.WriteString(w.out, w.color+text+colorReset+string(p[len(text):])); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
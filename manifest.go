@@ -0,0 +1,194 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Release manifest generation, describing every produced artifact.
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version identifier of the manifest schema, bumped on incompatible changes.
+const manifestSchema = "xgo-manifest/v1"
+
+// Manifest is the description of all the artifacts produced by a build, meant to
+// be consumed by release tooling.
+type Manifest struct {
+	Schema     string              `json:"schema"`             // Schema version of the document
+	Timestamp  time.Time           `json:"timestamp"`          // Time when the build was started
+	GoVersion  string              `json:"go_version"`         // Go release reported by the container
+	Image      string              `json:"image"`              // Docker image reference used for the build
+	Repository string              `json:"repository"`         // Root import path that was built
+	Package    string              `json:"package,omitempty"`  // Sub-package that was built, if any
+	Revision   string              `json:"revision,omitempty"` // Version control revision that was checked out
+	Flags      map[string]string   `json:"flags"`              // Command line flags explicitly set on xgo
+	Artifacts  []*ManifestArtifact `json:"artifacts"`          // Files produced by the build
+}
+
+// ManifestArtifact is a single produced file along with the platform it targets.
+type ManifestArtifact struct {
+	*Artifact
+	Path   string `json:"path"`             // Path of the artifact relative to the manifest
+	Target string `json:"target,omitempty"` // Target the artifact was built for, if known
+	OS     string `json:"os,omitempty"`     // Operating system of the target (GOOS)
+	Arch   string `json:"arch,omitempty"`   // Architecture of the target (GOARCH)
+	Inputs string `json:"inputs,omitempty"` // Fingerprint of the target's build inputs, if tracked
+}
+
+// Previous manifest of -only-changed-targets, whose unchanged targets are skipped.
+var baseline *Manifest
+
+// Returns the input fingerprints of the targets listed in a manifest.
+func manifestInputs(manifest *Manifest) map[string]string {
+	inputs := make(map[string]string)
+	if manifest != nil {
+		for _, artifact := range manifest.Artifacts {
+			if artifact.Target != "" && artifact.Inputs != "" {
+				inputs[artifact.Target] = artifact.Inputs
+			}
+		}
+	}
+	return inputs
+}
+
+// Assembles the manifest of a finished build and writes it as JSON into the
+// requested file. Artifact paths are relative to the manifest's own folder.
+func writeManifest(path string, image string, config *ConfigFlags, folder string, artifacts []string, report *BuildReport, started time.Time) error {
+	manifest := &Manifest{
+		Schema:     manifestSchema,
+		Timestamp:  started.UTC(),
+		GoVersion:  report.GoVersion,
+		Image:      image,
+		Repository: config.Repository,
+		Package:    config.Package,
+		Revision:   report.Revision,
+		Flags:      explicitFlags(),
+		Artifacts:  []*ManifestArtifact{},
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range artifacts {
+		// Don't list the manifest itself if it's written into the output folder
+		if abs == filepath.Join(folder, name) {
+			continue
+		}
+		artifact, err := inspectArtifact(folder, name)
+		if err != nil {
+			return err
+		}
+		if *buildIDs {
+			artifact.BuildID = readBuildID(filepath.Join(folder, name))
+		}
+		entry := &ManifestArtifact{Artifact: artifact, Path: name}
+		if rel, err := filepath.Rel(filepath.Dir(abs), filepath.Join(folder, name)); err == nil {
+			entry.Path = filepath.ToSlash(rel)
+		}
+		if output, ok := report.Outputs[name]; ok {
+			entry.Target, entry.Inputs = output.Target, report.Inputs[output.Target]
+			if target := findTarget(output.Target); target != nil {
+				entry.OS, entry.Arch = target.OS, target.Arch
+			}
+		}
+		manifest.Artifacts = append(manifest.Artifacts, entry)
+	}
+	// Carry over the artifacts of the targets skipped as unchanged, so the manifest
+	// stays a complete baseline for the next -only-changed-targets build
+	if baseline != nil {
+		for _, artifact := range baseline.Artifacts {
+			if stringInSlice(artifact.Target, report.Unchanged) {
+				manifest.Artifacts = append(manifest.Artifacts, artifact)
+			}
+		}
+	}
+	blob, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(blob, '\n'), 0644)
+}
+
+// Reads a previously written manifest, returning nil if it doesn't exist (yet).
+func readManifest(path string) (*Manifest, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	manifest := new(Manifest)
+	if err := json.Unmarshal(blob, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Compares the artifacts of a build by name and checksum against a previous
+// manifest, returning the ones that are new or changed. Without a previous
+// manifest all the artifacts are considered changed.
+func changedArtifacts(previous *Manifest, folder string, artifacts []string) ([]string, error) {
+	checksums := make(map[string]string)
+	if previous != nil {
+		for _, artifact := range previous.Artifacts {
+			if artifact.Artifact != nil {
+				checksums[artifact.Name] = artifact.SHA256
+			}
+		}
+	}
+	var changed []string
+	for _, name := range artifacts {
+		artifact, err := inspectArtifact(folder, name)
+		if err != nil {
+			return nil, err
+		}
+		if checksums[name] != artifact.SHA256 {
+			changed = append(changed, name)
+		}
+	}
+	return changed, nil
+}
+
+// Copies the given artifacts from the output folder into another one, creating
+// it if needed.
+func copyArtifacts(folder string, artifacts []string, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	for _, name := range artifacts {
+		if err := copyFile(filepath.Join(folder, name), filepath.Join(dest, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies a single file, preserving its permissions.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,76 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Build tag matrices, building all targets once per tag combination.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TagSet is a single build tag combination of a -tags-matrix build.
+type TagSet struct {
+	Label string // Label disambiguating the outputs of the set (e.g. sqlite-fts5)
+	Tags  string // Comma separated build tags of the set (empty = only the -tags ones)
+}
+
+// Tag sets to build every target with, parsed from -tags-matrix.
+var tagSets []*TagSet
+
+// Matcher for a tag set label, which ends up in the output file names.
+var tagSetLabelRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]*$`)
+
+// Parses the -tags-matrix values, each a [<label>=]<tags> tag set. Without an
+// explicit label the tags joined by dashes are used, so the set without extra
+// tags needs one.
+func parseTagsMatrix(values []string) ([]*TagSet, error) {
+	var sets []*TagSet
+	labels := make(map[string]bool)
+	for _, value := range values {
+		set := &TagSet{Tags: value}
+		if eq := strings.Index(value, "="); eq >= 0 {
+			set.Label, set.Tags = value[:eq], value[eq+1:]
+		}
+		tags := strings.FieldsFunc(set.Tags, func(r rune) bool { return r == ',' || r == ' ' })
+		set.Tags = strings.Join(tags, ",")
+		if set.Label == "" {
+			if len(tags) == 0 {
+				return nil, fmt.Errorf("tag set %q has no tags, label it explicitly (e.g. plain=)", value)
+			}
+			set.Label = strings.Join(tags, "-")
+		}
+		if !tagSetLabelRe.MatchString(set.Label) {
+			return nil, fmt.Errorf("invalid label %s of tag set %q (letters, digits, dots, dashes, pluses and underscores only)", set.Label, value)
+		}
+		if labels[set.Label] {
+			return nil, fmt.Errorf("tag set label %s is used more than once", set.Label)
+		}
+		labels[set.Label] = true
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// Returns the configuration and flags building a tag set: its outputs are named
+// <name>-<label>-<target> and its tags extend the global -tags ones, per target
+// overrides still applying on top.
+func tagSetBuild(config *ConfigFlags, flags *BuildFlags, set *TagSet) (*ConfigFlags, *BuildFlags) {
+	variant := *config
+	variant.Prefix = outputName(config) + "-" + set.Label
+
+	tags := flags.Tags.Default
+	switch {
+	case tags == "":
+		tags = set.Tags
+	case set.Tags != "":
+		tags += "," + set.Tags
+	}
+	build := *flags
+	build.Tags = &targetFlag{Default: tags, Overrides: flags.Tags.Overrides}
+
+	return &variant, &build
+}
@@ -0,0 +1,119 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// System package (deb, rpm) generation from the produced linux binaries.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Packaging tool used to assemble the system packages on the host.
+const packager = "nfpm"
+
+// System package formats that can be produced from the linux binaries.
+var packageFormats = map[string]bool{"deb": true, "rpm": true}
+
+// PackageInfo is the metadata to embed into the produced system packages.
+type PackageInfo struct {
+	Formats    []string // System package formats to produce (deb, rpm)
+	Name       string   // Name of the package and the installed binary
+	Version    string   // Version of the released package
+	Maintainer string   // Maintainer of the package (Name <email>)
+	Epoch      string   // Unix timestamp to set on all packaged files (empty = packaging time)
+}
+
+// Wraps every produced linux executable into the requested system packages. A
+// packager config is written next to each binary and fed to nfpm if it's installed
+// on the host, otherwise only the configs are left behind for a later run.
+func buildPackages(info *PackageInfo, folder string, artifacts []string, report *BuildReport) error {
+	tool, err := runner.LookPath(packager)
+	if err != nil {
+		warnf("Packaging tool %s not found, only writing its configs (see https://nfpm.goreleaser.com).", packager)
+	}
+	var failed []string
+	for _, name := range artifacts {
+		output, ok := report.Outputs[name]
+		if !ok {
+			continue
+		}
+		target := findTarget(output.Target)
+		if target == nil || target.OS != "linux" || strings.HasSuffix(name, ".so") || strings.HasSuffix(name, ".a") {
+			continue
+		}
+		config := filepath.Join(folder, name+".nfpm.yaml")
+		if err := writePackagerConfig(config, info, filepath.Join(folder, name), target); err != nil {
+			return err
+		}
+		for _, format := range info.Formats {
+			if tool == "" {
+				fmt.Fprintf(logOutput, "Package config for %s: %s (run %s package --config %s --packager %s --target %s)\n", name, config, packager, config, format, folder)
+				continue
+			}
+			fmt.Fprintf(infoOutput, "Packaging %s as %s...\n", name, format)
+			cmd := runner.Command(tool, "package", "--config", config, "--packager", format, "--target", folder)
+			if info.Epoch != "" {
+				cmd.Env = append(os.Environ(), "SOURCE_DATE_EPOCH="+info.Epoch)
+			}
+			if err := run(cmd); err != nil {
+				warnf("Failed to package %s as %s: %v.", name, format, err)
+				failed = append(failed, name+" ("+format+")")
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("packaging failed for %d artifact(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Writes an nfpm config installing a single binary as /usr/bin/<name>, stamping the
+// package and its files with the source date if requested.
+func writePackagerConfig(file string, info *PackageInfo, binary string, target *Target) error {
+	// Map the target to nfpm's architecture naming (GOARCH, with arm suffixed by GOARM)
+	arch := target.Arch
+	if arch == "arm" {
+		arch = "arm5"
+	}
+	binary, err := filepath.Abs(binary)
+	if err != nil {
+		return err
+	}
+	lines := []string{
+		"name: " + strconv.Quote(info.Name),
+		"arch: " + strconv.Quote(arch),
+		"platform: linux",
+		"version: " + strconv.Quote(info.Version),
+		"description: " + strconv.Quote("Cross compiled by xgo for "+target.Name),
+	}
+	if info.Maintainer != "" {
+		lines = append(lines, "maintainer: "+strconv.Quote(info.Maintainer))
+	}
+	var mtime string
+	if info.Epoch != "" {
+		secs, err := strconv.ParseInt(info.Epoch, 10, 64)
+		if err != nil {
+			return err
+		}
+		mtime = strconv.Quote(time.Unix(secs, 0).UTC().Format(time.RFC3339))
+		lines = append(lines, "mtime: "+mtime)
+	}
+	lines = append(lines,
+		"contents:",
+		"  - src: "+strconv.Quote(binary),
+		"    dst: "+strconv.Quote("/usr/bin/"+info.Name),
+		"    file_info:",
+		"      mode: 0755",
+	)
+	if mtime != "" {
+		lines = append(lines, "      mtime: "+mtime)
+	}
+	return os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,177 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Live build progress tracking from the container's logs, served over HTTP.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BuildProgress is the live status of a running build, tracked by following the
+// container's logs and served as JSON via -serve.
+type BuildProgress struct {
+	State     string            `json:"state"`             // Phase of the build (building, finished, failed)
+	Current   string            `json:"current,omitempty"` // Target currently being built, if any
+	Completed int               `json:"completed"`         // Number of targets done (built, skipped or failed)
+	Total     int               `json:"total"`             // Number of targets to build
+	Targets   []*TargetProgress `json:"targets"`           // Status of every target to build
+
+	lock   sync.Mutex // Protects the fields above from concurrent logs and requests
+	repo   string     // Repository whose targets are currently built
+	buffer []byte     // Partial log line not yet terminated
+}
+
+// TargetProgress is the live status of a single target.
+type TargetProgress struct {
+	Name       string  `json:"name"`               // Name of the target (e.g. linux-arm)
+	Repository string  `json:"repository"`         // Import path the target is built for
+	Status     string  `json:"status"`             // Status of the target (pending, building, built, skipped, failed)
+	Duration   float64 `json:"duration,omitempty"` // Time it took to build the target in seconds
+	platform   string  // GOOS/GOARCH pair the container logs refer to the target by
+}
+
+// Progress of the running build if served via -serve, nil otherwise.
+var progress *BuildProgress
+
+// Registers the targets of a repository about to be built as pending.
+func (p *BuildProgress) begin(repo string, targets []*Target) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.State, p.repo = "building", repo
+	for _, target := range targets {
+		p.Targets = append(p.Targets, &TargetProgress{Name: target.Name, Repository: repo, Status: "pending", platform: target.Platform()})
+	}
+	p.Total += len(targets)
+}
+
+// Marks the end of a repository's build, failing its target still in progress
+// if the build was aborted.
+func (p *BuildProgress) end(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err != nil {
+		for _, target := range p.Targets {
+			if target.Repository == p.repo && target.Status == "building" {
+				target.Status = "failed"
+				p.Completed++
+			}
+		}
+	}
+	p.Current = ""
+}
+
+// Marks the whole build as done, either finished or failed.
+func (p *BuildProgress) finish(failed bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.State = "finished"
+	if failed {
+		p.State = "failed"
+	}
+}
+
+// Follows the container's logs, updating the target statuses on the build script's
+// progress lines.
+func (p *BuildProgress) Write(data []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.buffer = append(p.buffer, data...)
+	for {
+		end := bytes.IndexByte(p.buffer, '\n')
+		if end < 0 {
+			return len(data), nil
+		}
+		p.line(strings.TrimRight(string(p.buffer[:end]), "\r"))
+		p.buffer = p.buffer[end+1:]
+	}
+}
+
+// Updates the target statuses based on a single log line of the build script.
+func (p *BuildProgress) line(line string) {
+	switch {
+	case strings.HasPrefix(line, "Compiling for "):
+		if target := p.next(strings.TrimSuffix(strings.TrimPrefix(line, "Compiling for "), "...")); target != nil {
+			target.Status, p.Current = "building", target.Name
+		}
+	case strings.HasPrefix(line, "Skipping ") && strings.HasSuffix(line, " is up to date") && len(strings.Fields(line)) > 2:
+		if target := p.next(strings.TrimSuffix(strings.Fields(line)[1], ",")); target != nil {
+			target.Status = "skipped"
+			p.Completed++
+		}
+	case strings.HasPrefix(line, "Finished ") && len(strings.Fields(line)) > 1:
+		fields := strings.Fields(line)
+		for _, target := range p.Targets {
+			if target.Repository == p.repo && target.Status == "building" && target.platform == fields[1] {
+				target.Status = "built"
+				if len(fields) == 4 {
+					target.Duration, _ = strconv.ParseFloat(strings.TrimSuffix(fields[3], "s"), 64)
+				}
+				p.Completed, p.Current = p.Completed+1, ""
+				break
+			}
+		}
+	case strings.HasPrefix(line, "Failed to build ") && len(strings.Fields(line)) > 3:
+		name := strings.TrimSuffix(strings.Fields(line)[3], ",")
+		for _, target := range p.Targets {
+			if target.Repository == p.repo && target.Name == name && target.Status != "failed" {
+				target.Status = "failed"
+				p.Completed, p.Current = p.Completed+1, ""
+				break
+			}
+		}
+	}
+}
+
+// Returns the next pending target of the current repository building for the
+// given platform. The container builds them in order, so the first one matches.
+func (p *BuildProgress) next(platform string) *TargetProgress {
+	for _, target := range p.Targets {
+		if target.Repository == p.repo && target.Status == "pending" && target.platform == platform {
+			return target
+		}
+	}
+	return nil
+}
+
+// Serves the build progress as JSON on the given address until the returned
+// function is called, shutting the server down.
+func serveProgress(addr string, progress *BuildProgress) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		progress.lock.Lock()
+		blob, err := json.MarshalIndent(progress, "", "  ")
+		progress.lock.Unlock()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(blob, '\n'))
+	})}
+	go server.Serve(listener)
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,191 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Build artifact tracking and provenance metadata generation.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version identifier of the provenance schema, bumped on incompatible changes.
+const provenanceSchema = "xgo-provenance/v1"
+
+// Provenance is the metadata recorded about a single xgo invocation.
+type Provenance struct {
+	Schema     string            `json:"schema"`             // Schema version of the document
+	Timestamp  time.Time         `json:"timestamp"`          // Time when the build was started
+	GoVersion  string            `json:"go_version"`         // Go release reported by the container
+	Image      string            `json:"image"`              // Docker image reference used for the build
+	ImageID    string            `json:"image_id"`           // Content digest of the docker image used
+	Repository string            `json:"repository"`         // Root import path that was built
+	Package    string            `json:"package,omitempty"`  // Sub-package that was built, if any
+	Remote     string            `json:"remote,omitempty"`   // Version control remote that was built, if any
+	Branch     string            `json:"branch,omitempty"`   // Version control branch that was built, if any
+	Revision   string            `json:"revision,omitempty"` // Version control revision that was checked out
+	Flags      map[string]string `json:"flags"`              // Command line flags explicitly set on xgo
+	Artifacts  []*Artifact       `json:"artifacts"`          // Build outputs produced by the invocation
+}
+
+// Artifact is a single file produced by the cross compilation.
+type Artifact struct {
+	Name    string `json:"name"`               // File name of the artifact within the output folder
+	Size    int64  `json:"size"`               // Size of the artifact in bytes
+	SHA256  string `json:"sha256"`             // Hex encoded SHA256 checksum of the artifact
+	BuildID string `json:"build_id,omitempty"` // Go build ID embedded in the artifact, if requested
+}
+
+// Collects the regular files in a folder, so that newly produced artifacts can
+// be detected after a build. The -log-file is skipped, being written during it.
+func snapshotDir(folder string) (map[string]os.FileInfo, error) {
+	infos, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]os.FileInfo)
+	for _, entry := range infos {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() && (logFileInfo == nil || !os.SameFile(info, logFileInfo)) {
+			files[info.Name()] = info
+		}
+	}
+	return files, nil
+}
+
+// Returns the names of the files that were created or modified between two
+// folder snapshots, sorted alphabetically.
+func newArtifacts(before, after map[string]os.FileInfo) []string {
+	var names []string
+	for name, info := range after {
+		if strings.HasPrefix(name, ".xgo") {
+			continue
+		}
+		if old, ok := before[name]; ok && old.Size() == info.Size() && old.ModTime().Equal(info.ModTime()) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the artifacts of a build that replaced files already present in the
+// folder snapshot taken before it.
+func clobberedArtifacts(before map[string]os.FileInfo, artifacts []string) []string {
+	var names []string
+	for _, name := range artifacts {
+		if _, ok := before[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Gathers the size and checksum of an artifact in the output folder.
+func inspectArtifact(folder, name string) (*Artifact, error) {
+	file, err := os.Open(filepath.Join(folder, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Name: name, Size: size, SHA256: hex.EncodeToString(hasher.Sum(nil))}, nil
+}
+
+// Matcher for the Go build ID in the output of the file utility.
+var fileBuildIDRe = regexp.MustCompile(`Go BuildID=([^,\s]+)`)
+
+// Retrieves the Go build ID embedded in an artifact via the host's go tool,
+// falling back to the file utility if that fails (e.g. Go is not installed or
+// can't parse the binary format). An empty ID is returned if both fail.
+func readBuildID(path string) string {
+	if out, err := runner.Output(runner.Command("go", "tool", "buildid", path)); err == nil {
+		return strings.TrimSpace(string(out))
+	}
+	if out, err := runner.Output(runner.Command("file", path)); err == nil {
+		if match := fileBuildIDRe.FindSubmatch(out); match != nil {
+			return string(match[1])
+		}
+	}
+	return ""
+}
+
+// Retrieves the content digest of a local docker image.
+func inspectDockerImage(image string) (string, error) {
+	out, err := runner.Output(dockerCommand("inspect", "--format", "{{.Id}}", image))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Collects the command line flags explicitly set on xgo (or via the environment).
+func explicitFlags() map[string]string {
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
+
+// Assembles the provenance metadata of a finished build and writes it as JSON
+// into the requested file.
+func writeProvenance(path string, image string, config *ConfigFlags, folder string, artifacts []string, report *BuildReport, started time.Time) error {
+	digest, err := inspectDockerImage(image)
+	if err != nil {
+		return err
+	}
+	prov := &Provenance{
+		Schema:     provenanceSchema,
+		Timestamp:  started.UTC(),
+		GoVersion:  report.GoVersion,
+		Image:      image,
+		ImageID:    digest,
+		Repository: config.Repository,
+		Package:    config.Package,
+		Remote:     config.Remote,
+		Branch:     config.Branch,
+		Revision:   report.Revision,
+		Flags:      explicitFlags(),
+		Artifacts:  []*Artifact{},
+	}
+	for _, name := range artifacts {
+		// Don't list the provenance file itself if it's written into the output folder
+		if abs, err := filepath.Abs(path); err == nil && abs == filepath.Join(folder, name) {
+			continue
+		}
+		artifact, err := inspectArtifact(folder, name)
+		if err != nil {
+			return err
+		}
+		if *buildIDs {
+			artifact.BuildID = readBuildID(filepath.Join(folder, name))
+		}
+		prov.Artifacts = append(prov.Artifacts, artifact)
+	}
+	blob, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(blob, '\n'), 0644)
+}
@@ -0,0 +1,50 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Tests of the build artifact tracking.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tests that a log file written into the output folder during the build is not
+// detected as one of its artifacts, nor as a clobbered file.
+func TestNewArtifactsSkipLogFile(t *testing.T) {
+	folder := t.TempDir()
+	if err := os.WriteFile(filepath.Join(folder, "build.log"), []byte("previous log\n"), 0644); err != nil {
+		t.Fatalf("failed to write the previous log: %v", err)
+	}
+	defer func(old os.FileInfo) { logFileInfo = old }(logFileInfo)
+
+	file, err := openLogFile(filepath.Join(folder, "build.log"))
+	if err != nil {
+		t.Fatalf("failed to open the log file: %v", err)
+	}
+	defer file.Close()
+
+	before, err := snapshotDir(folder)
+	if err != nil {
+		t.Fatalf("failed to snapshot the output folder: %v", err)
+	}
+	file.WriteString("Compiling for linux/amd64...\n")
+	if err := os.WriteFile(filepath.Join(folder, "iris-linux-amd64"), []byte("binary"), 0755); err != nil {
+		t.Fatalf("failed to write the artifact: %v", err)
+	}
+	after, err := snapshotDir(folder)
+	if err != nil {
+		t.Fatalf("failed to snapshot the output folder: %v", err)
+	}
+	artifacts := newArtifacts(before, after)
+	if have := strings.Join(artifacts, ","); have != "iris-linux-amd64" {
+		t.Errorf("artifacts mismatch: have %s, want iris-linux-amd64", have)
+	}
+	if clobbered := clobberedArtifacts(before, artifacts); len(clobbered) > 0 {
+		t.Errorf("unexpected clobbered files: %v", clobbered)
+	}
+}
@@ -0,0 +1,336 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Discovery of the Go releases available as xgo images, and wildcard matching.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP client used to query docker registries, with a sane timeout.
+var registryClient = &http.Client{Timeout: 15 * time.Second}
+
+// Checks whether a Go release is a wildcard pattern (e.g. 1.21.x) rather than a
+// concrete release. The legacy literal images (e.g. 1.4.x) are not patterns.
+func isVersionPattern(version string) bool {
+	if stringInSlice(version, knownReleases) {
+		return false
+	}
+	for _, part := range strings.Split(version, ".") {
+		if part == "x" || part == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a concrete numeric release (e.g. 1.21.3) into its components, returning
+// nil for anything else (e.g. latest, 1.21rc1, 1.4.x).
+func parseVersion(version string) []int {
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// Checks whether a concrete release matches a wildcard pattern, each x (or *)
+// matching any number and missing components being treated as zeroes.
+func matchVersion(pattern string, version string) bool {
+	parts := parseVersion(version)
+	if parts == nil {
+		return false
+	}
+	wants := strings.Split(pattern, ".")
+	if len(parts) > len(wants) {
+		return false
+	}
+	for i, want := range wants {
+		have := 0
+		if i < len(parts) {
+			have = parts[i]
+		}
+		if want == "x" || want == "*" {
+			continue
+		}
+		if n, err := strconv.Atoi(want); err != nil || n != have {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks whether release a is older than release b, both being concrete ones.
+func versionLess(a, b string) bool {
+	pa, pb := parseVersion(a), parseVersion(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return len(pa) < len(pb)
+}
+
+// Resolves a wildcard Go release pattern to the newest matching release available
+// as an image, preferring the registry and falling back to the local images.
+func resolveGoVersion(pattern string) (string, error) {
+	versions, err := listRemoteVersions(*imageRepo)
+	source := "the registry"
+	if err != nil {
+		warnf("Failed to list the releases in the registry: %v, falling back to the local images.", err)
+		if versions, err = listLocalVersions(*imageRepo); err != nil {
+			return "", err
+		}
+		source = "the local images"
+	}
+	var best string
+	for _, version := range versions {
+		if matchVersion(pattern, version) && (best == "" || versionLess(best, version)) {
+			best = version
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no release matching %s found in %s of %s*", pattern, source, *imageRepo)
+	}
+	return best, nil
+}
+
+// Matcher for the Go pre-releases (e.g. 1.21rc1 or 1.22beta2).
+var preReleaseRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*(rc|beta)[0-9]+$`)
+
+// Checks whether an image name (or tag) suffix denotes a Go release, rather than
+// another image sharing the prefix (e.g. the base image xgo-base).
+func isReleaseName(version string) bool {
+	return parseVersion(version) != nil || preReleaseRe.MatchString(version) || stringInSlice(version, knownReleases)
+}
+
+// Lists the Go releases available locally as images of the given repository prefix.
+func listLocalVersions(prefix string) ([]string, error) {
+	out, err := runner.Output(dockerCommand("images"))
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		repo := unqualifiedRepo(fields[0])
+		if repo == dockerBase {
+			continue
+		}
+		image := repo + ":" + fields[1]
+		if strings.HasSuffix(prefix, "-") {
+			image = repo // Releases are repositories, not tags
+		}
+		if strings.HasPrefix(image, prefix) {
+			if version := strings.TrimPrefix(image, prefix); isReleaseName(version) && !stringInSlice(version, versions) {
+				versions = append(versions, version)
+			}
+		}
+	}
+	sort.Strings(versions)
+	return versions, scanner.Err()
+}
+
+// Lists the Go releases available in the registry for the given repository prefix.
+// Prefixes ending in a colon (registry/repo:) have a tag per release, which are
+// listed via the registry API, whereas the stock scheme (user/xgo-) has a Docker
+// Hub repository per release, which are listed via the Docker Hub API.
+func listRemoteVersions(prefix string) ([]string, error) {
+	if strings.HasSuffix(prefix, ":") {
+		tags, err := listRegistryTags(strings.TrimSuffix(prefix, ":"))
+		if err != nil {
+			return nil, err
+		}
+		var versions []string
+		for _, tag := range tags {
+			if isReleaseName(tag) {
+				versions = append(versions, tag)
+			}
+		}
+		return versions, nil
+	}
+	namespace := strings.SplitN(prefix, "/", 2)
+	if len(namespace) != 2 || strings.ContainsAny(namespace[0], ".:") {
+		return nil, fmt.Errorf("releases of %s can only be listed on Docker Hub", prefix)
+	}
+	var versions []string
+
+	next := "https://hub.docker.com/v2/repositories/" + namespace[0] + "/?page_size=100"
+	for next != "" {
+		var page struct {
+			Next    string `json:"next"`
+			Results []struct {
+				Name string `json:"name"`
+			} `json:"results"`
+		}
+		if err := registryGet(next, "", &page); err != nil {
+			return nil, err
+		}
+		for _, repo := range page.Results {
+			if namespace[0]+"/"+repo.Name == dockerBase || !strings.HasPrefix(repo.Name, namespace[1]) {
+				continue
+			}
+			if version := strings.TrimPrefix(repo.Name, namespace[1]); isReleaseName(version) {
+				versions = append(versions, version)
+			}
+		}
+		next = page.Next
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// Lists all the tags of a repository via the docker registry v2 API, fetching an
+// anonymous bearer token first if the registry requests one.
+func listRegistryTags(repo string) ([]string, error) {
+	host, name := "registry-1.docker.io", repo
+	if parts := strings.SplitN(repo, "/", 2); len(parts) == 2 && strings.ContainsAny(parts[0], ".:") {
+		host, name = parts[0], parts[1]
+	} else if !strings.Contains(repo, "/") {
+		name = "library/" + repo
+	}
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	endpoint := "https://" + host + "/v2/" + name + "/tags/list"
+
+	err := registryGet(endpoint, "", &list)
+	if challenge, ok := err.(*authChallenge); ok {
+		token, terr := fetchRegistryToken(challenge.header)
+		if terr != nil {
+			return nil, terr
+		}
+		err = registryGet(endpoint, token, &list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(list.Tags)
+	return list.Tags, nil
+}
+
+// authChallenge is the error returned for responses requesting authentication.
+type authChallenge struct {
+	header string // Contents of the WWW-Authenticate header
+}
+
+func (c *authChallenge) Error() string {
+	return "registry requires authentication"
+}
+
+// Retrieves a JSON document from a registry, optionally with a bearer token, and
+// decodes it. Authentication requests and rate limits are reported as such.
+func registryGet(endpoint string, token string, result interface{}) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := registryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized && token == "" && res.Header.Get("WWW-Authenticate") != "":
+		return &authChallenge{header: res.Header.Get("WWW-Authenticate")}
+	case res.StatusCode == http.StatusTooManyRequests:
+		if retry := res.Header.Get("Retry-After"); retry != "" {
+			return fmt.Errorf("registry rate limit exceeded, retry after %ss", retry)
+		}
+		return fmt.Errorf("registry rate limit exceeded, retry later")
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("registry returned %s for %s", res.Status, endpoint)
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// Matcher for the parameters of a bearer authentication challenge.
+var challengeParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Fetches an anonymous pull token as requested by a bearer authentication challenge.
+func fetchRegistryToken(challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication: %s", challenge)
+	}
+	params := make(map[string]string)
+	for _, match := range challengeParamRe.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("registry authentication challenge without realm: %s", challenge)
+	}
+	query := url.Values{"service": {params["service"]}, "scope": {params["scope"]}}
+	endpoint := params["realm"] + "?" + query.Encode()
+
+	// Authenticate the token request if credentials were provided
+	if user := os.Getenv("XGO_REGISTRY_USER"); user != "" {
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return "", err
+		}
+		parsed.User = url.UserPassword(user, os.Getenv("XGO_REGISTRY_PASSWORD"))
+		endpoint = parsed.String()
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := registryGet(endpoint, "", &token); err != nil {
+		return "", fmt.Errorf("failed to obtain a registry token (set XGO_REGISTRY_USER and XGO_REGISTRY_PASSWORD for private registries): %v", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// Sorts a list of releases from oldest to newest, placing the non numeric ones
+// (e.g. latest, tip, 1.4.x) alphabetically at the end.
+func sortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		a, b := parseVersion(versions[i]) != nil, parseVersion(versions[j]) != nil
+		switch {
+		case a && b:
+			return versionLess(versions[i], versions[j])
+		case a != b:
+			return a
+		default:
+			return versions[i] < versions[j]
+		}
+	})
+}
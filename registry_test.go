@@ -0,0 +1,39 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Tests of the Go release discovery from the local images.
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that only actual Go releases are listed from the local images, skipping the
+// base image and anything else sharing the repository prefix.
+func TestListLocalVersions(t *testing.T) {
+	images := strings.Join([]string{
+		"REPOSITORY                  TAG      IMAGE ID       CREATED        SIZE",
+		"karalabe/xgo-1.21.3         latest   0123456789ab   2 weeks ago    2.1GB",
+		"docker.io/karalabe/xgo-1.20 latest   123456789abc   3 months ago   2GB",
+		"karalabe/xgo-1.22rc1        latest   23456789abcd   4 months ago   2.2GB",
+		"karalabe/xgo-latest         latest   3456789abcde   2 weeks ago    2.1GB",
+		"karalabe/xgo-base           latest   456789abcdef   2 weeks ago    1.5GB",
+		"docker.io/karalabe/xgo-base latest   56789abcdef0   5 months ago   1.4GB",
+		"karalabe/xgo-mybuild        latest   6789abcdef01   1 day ago      2.1GB",
+		"karalabe/xgo-1.21.3         <none>   789abcdef012   2 weeks ago    2.1GB",
+		"golang                      1.21     89abcdef0123   2 weeks ago    800MB",
+	}, "\n")
+	fake := &fakeRunner{respond: func(args []string) (string, int, string) { return images, 0, "" }}
+	useFakeRunner(t, fake, "24.0.7")
+
+	versions, err := listLocalVersions("karalabe/xgo-")
+	if err != nil {
+		t.Fatalf("failed to list the local releases: %v", err)
+	}
+	if have, want := strings.Join(versions, ","), "1.20,1.21.3,1.22rc1,latest"; have != want {
+		t.Errorf("releases mismatch: have %s, want %s", have, want)
+	}
+}
@@ -0,0 +1,259 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Container build report parsing and end of build summaries.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Name of the report file the container leaves in the output folder, holding
+// details only known inside (e.g. the checked out revision, target timings).
+const buildReportFile = ".xgo-report"
+
+// BuildReport is the metadata reported by the container about a finished build.
+type BuildReport struct {
+	GoVersion    string                   // Go release used inside the container
+	Revision     string                   // Version control revision that was built
+	Dependencies []*Dependency            // CGO dependency archives that were downloaded
+	Outputs      map[string]*TargetReport // Details of the built targets, keyed by output
+	Inputs       map[string]string        // Input fingerprints of the targets, keyed by target
+	Unchanged    []string                 // Targets skipped as their inputs were unchanged
+	Skipped      []*SkippedTarget         // Selected targets that weren't built, with the reasons
+}
+
+// SkippedTarget is a selected target that wasn't built, along with the reason why.
+type SkippedTarget struct {
+	Target string `json:"target"` // Name of the skipped target, as selected
+	Reason string `json:"reason"` // Human readable reason of the skip (e.g. up to date)
+}
+
+func (s *SkippedTarget) String() string { return s.Target + " (" + s.Reason + ")" }
+
+// Joins skipped targets into a comma separated list, each with its reason.
+func joinSkipped(skipped []*SkippedTarget) string {
+	items := make([]string, len(skipped))
+	for i, target := range skipped {
+		items[i] = target.String()
+	}
+	return strings.Join(items, ", ")
+}
+
+// Dependency is a CGO dependency archive along with its content checksum.
+type Dependency struct {
+	URL    string `json:"url"`    // Location the archive was downloaded from
+	SHA256 string `json:"sha256"` // Hex encoded SHA256 checksum of the archive
+}
+
+// TargetReport is the metadata reported by the container about a single target.
+type TargetReport struct {
+	Target   string        // Name of the target that was built
+	Output   string        // File name of the produced output
+	Duration time.Duration // Time it took to build the target
+}
+
+// Reads and deletes the build report left behind by the container. A missing
+// report (e.g. custom images) results in an empty one, not an error.
+//
+// The report is line based, each line having a type and space separated fields:
+//
+//	go <version>
+//	revision <revision>
+//	dep <URL> <SHA256>
+//	built <target> <output> <seconds>
+//	inputs <target> <fingerprint>
+//	unchanged <target>
+//	skipped <target> <reason...>
+func readBuildReport(folder string) (*BuildReport, error) {
+	report := &BuildReport{Outputs: make(map[string]*TargetReport), Inputs: make(map[string]string)}
+
+	path := filepath.Join(folder, buildReportFile)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return report, nil
+		}
+		return nil, err
+	}
+	defer os.Remove(path)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "go":
+			report.GoVersion = fields[1]
+		case "revision":
+			report.Revision = fields[1]
+		case "dep":
+			if len(fields) == 3 {
+				report.Dependencies = append(report.Dependencies, &Dependency{URL: fields[1], SHA256: fields[2]})
+			}
+		case "inputs":
+			if len(fields) == 3 {
+				report.Inputs[fields[1]] = fields[2]
+			}
+		case "unchanged":
+			report.Unchanged = append(report.Unchanged, fields[1])
+			report.Skipped = append(report.Skipped, &SkippedTarget{Target: fields[1], Reason: "inputs unchanged"})
+		case "skipped":
+			if len(fields) > 2 {
+				report.Skipped = append(report.Skipped, &SkippedTarget{Target: fields[1], Reason: strings.Join(fields[2:], " ")})
+			}
+		case "built":
+			if len(fields) == 4 {
+				secs, _ := strconv.Atoi(fields[3])
+				report.Outputs[fields[2]] = &TargetReport{
+					Target:   fields[1],
+					Output:   fields[2],
+					Duration: time.Duration(secs) * time.Second,
+				}
+			}
+		}
+	}
+	return report, scanner.Err()
+}
+
+// Merges the report of another build (e.g. of another repository) into this one,
+// the metadata of the later build taking precedence.
+func (r *BuildReport) merge(other *BuildReport) {
+	if other.GoVersion != "" {
+		r.GoVersion = other.GoVersion
+	}
+	if other.Revision != "" {
+		r.Revision = other.Revision
+	}
+	r.Dependencies = append(r.Dependencies, other.Dependencies...)
+	for name, output := range other.Outputs {
+		r.Outputs[name] = output
+	}
+	for target, inputs := range other.Inputs {
+		r.Inputs[target] = inputs
+	}
+	r.Unchanged = append(r.Unchanged, other.Unchanged...)
+	for _, skip := range other.Skipped {
+		if !r.skipped(skip) {
+			r.Skipped = append(r.Skipped, skip)
+		}
+	}
+}
+
+// Checks whether a target was already reported skipped for the same reason (e.g.
+// by the build of another repository).
+func (r *BuildReport) skipped(skip *SkippedTarget) bool {
+	for _, other := range r.Skipped {
+		if *other == *skip {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats a byte count in a human friendly form (e.g. 9.8 MB).
+func humanSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// Prints a short summary of a finished build: the total time it took, and the
+// size (and build time where reported by the container) of every artifact.
+func printSummary(folder string, artifacts []string, report *BuildReport, elapsed time.Duration) {
+	var total int64
+
+	lines := make([]string, 0, len(artifacts))
+	for _, name := range artifacts {
+		info, err := os.Stat(filepath.Join(folder, name))
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+
+		line := fmt.Sprintf("  %-40s %10s", name, humanSize(info.Size()))
+		if target, ok := report.Outputs[name]; ok {
+			line += fmt.Sprintf("  (%v)", target.Duration)
+		}
+		lines = append(lines, line)
+	}
+	fmt.Fprintf(infoOutput, "\n%s\n", paint(colorGreen, fmt.Sprintf("Build finished in %v, produced %d artifact(s) totalling %s.", elapsed.Round(time.Second), len(lines), humanSize(total))))
+	for _, line := range lines {
+		fmt.Fprintln(infoOutput, line)
+	}
+	if len(report.Skipped) > 0 {
+		fmt.Fprintf(infoOutput, "Skipped: %s\n", joinSkipped(report.Skipped))
+	}
+}
+
+// BuildResult is the machine readable outcome of a build, printed in JSON mode.
+type BuildResult struct {
+	GoVersion string            `json:"go_version"`         // Go release reported by the container
+	Image     string            `json:"image"`              // Docker image the build ran in
+	Revision  string            `json:"revision,omitempty"` // Version control revision that was built
+	Duration  float64           `json:"duration"`           // Total build time in seconds
+	Artifacts []*ResultArtifact `json:"artifacts"`          // Files produced by the build
+	Skipped   []*SkippedTarget  `json:"skipped"`            // Selected targets that weren't built
+}
+
+// ResultArtifact is a single produced file along with the target it belongs to.
+type ResultArtifact struct {
+	*Artifact
+	Target   string  `json:"target,omitempty"`   // Target the artifact was built for, if known
+	Duration float64 `json:"duration,omitempty"` // Time it took to build the target in seconds
+	URL      string  `json:"url,omitempty"`      // Location the artifact was uploaded to, if any
+}
+
+// Prints the outcome of a build as JSON onto stdout.
+func printResult(image string, folder string, artifacts []string, report *BuildReport, urls map[string]string, elapsed time.Duration) error {
+	result := &BuildResult{
+		GoVersion: report.GoVersion,
+		Image:     image,
+		Revision:  report.Revision,
+		Duration:  elapsed.Seconds(),
+		Artifacts: []*ResultArtifact{},
+		Skipped:   report.Skipped,
+	}
+	if result.Skipped == nil {
+		result.Skipped = []*SkippedTarget{}
+	}
+	for _, name := range artifacts {
+		artifact, err := inspectArtifact(folder, name)
+		if err != nil {
+			return err
+		}
+		if *buildIDs {
+			artifact.BuildID = readBuildID(filepath.Join(folder, name))
+		}
+		entry := &ResultArtifact{Artifact: artifact, URL: urls[name]}
+		if target, ok := report.Outputs[name]; ok {
+			entry.Target, entry.Duration = target.Target, target.Duration.Seconds()
+		}
+		result.Artifacts = append(result.Artifacts, entry)
+	}
+	blob, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", blob)
+	return err
+}
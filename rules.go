@@ -0,0 +1,87 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Known incompatible command line flag combinations and their validation.
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// flagRule is a known problematic relation between two command line flags.
+type flagRule struct {
+	Flag    string // Flag the rule is checked for when it's explicitly set
+	Other   string // Flag that conflicts with it, or that it requires
+	Require bool   // Whether the other flag is required instead of conflicting
+	Fatal   bool   // Whether a violation is an error, or only a warning
+	Advice  string // Guidance on what to do instead
+}
+
+// All the known flag incompatibilities, checked before doing anything else.
+var flagRules = []flagRule{
+	{Flag: "local", Other: "remote", Fatal: true, Advice: "local sources are used as is, check out the desired remote locally instead"},
+	{Flag: "local", Other: "branch", Fatal: true, Advice: "local sources are used as is, check out the desired branch locally instead"},
+	{Flag: "source-archive", Other: "local", Fatal: true, Advice: "build either the archive or the local folder"},
+	{Flag: "source-archive", Other: "remote", Fatal: true, Advice: "archived sources are used as is, archive the desired remote instead"},
+	{Flag: "source-archive", Other: "branch", Fatal: true, Advice: "archived sources are used as is, archive the desired branch instead"},
+	{Flag: "watch", Other: "local", Require: true, Fatal: true, Advice: "only local sources can be watched for changes"},
+	{Flag: "testbin", Other: "buildmode", Fatal: true, Advice: "test binaries are always executables"},
+	{Flag: "msan", Other: "race", Fatal: true, Advice: "the Go toolchain supports a single instrumentation per build, build each separately"},
+	{Flag: "asan", Other: "race", Fatal: true, Advice: "the Go toolchain supports a single instrumentation per build, build each separately"},
+	{Flag: "asan", Other: "msan", Fatal: true, Advice: "the Go toolchain supports a single instrumentation per build, build each separately"},
+	{Flag: "package", Other: "version", Require: true, Fatal: true, Advice: "system packages must be versioned"},
+	{Flag: "changed-out", Other: "changed-since", Require: true, Fatal: true, Advice: "changes are detected against the previous manifest"},
+	{Flag: "serve", Other: "watch", Fatal: true, Advice: "the progress is only tracked for the initial build"},
+	{Flag: "verify", Other: "watch", Fatal: true, Advice: "only the initial build is verified, verify the watched sources separately"},
+	{Flag: "tags-matrix", Other: "verify", Fatal: true, Advice: "only a single tag set would be verified, verify each set separately"},
+	{Flag: "tags-matrix", Other: "watch", Fatal: true, Advice: "rebuilding every tag set on each change is too slow, watch a single set via -tags instead"},
+	{Flag: "tags-matrix", Other: "name", Fatal: true, Advice: "the exact per target names would collide across the tag sets"},
+	{Flag: "tags-matrix", Other: "only-changed-targets", Fatal: true, Advice: "the target inputs are tracked per target, not per tag set"},
+	{Flag: "tags-matrix", Other: "package", Fatal: true, Advice: "the system packages would bundle the binaries of every tag set, package each set separately"},
+	{Flag: "clean-build", Other: "skip-existing", Fatal: true, Advice: "a clean build rebuilds every target from scratch"},
+	{Flag: "clean-build", Other: "only-changed-targets", Fatal: true, Advice: "a clean build rebuilds every target from scratch"},
+	{Flag: "summary-only", Other: "github", Advice: "the annotations of errors and warnings are only emitted by streamed builds"},
+	{Flag: "upload-endpoint", Other: "upload", Require: true, Fatal: true, Advice: "the endpoint only applies to the upload destination"},
+	{Flag: "tmpfs-size", Other: "tmpfs", Require: true, Fatal: true, Advice: "the size only limits the tmpfs build space"},
+	{Flag: "only-changed-targets", Other: "manifest", Require: true, Advice: "without a new manifest the next build has no baseline to skip against"},
+	{Flag: "image-tag", Other: "go", Advice: "the pinned image is used regardless of the Go release"},
+	{Flag: "image-tag", Other: "image-repo", Advice: "the pinned image is used regardless of the image repository"},
+	{Flag: "strip-build-id", Other: "buildid", Advice: "the recorded build IDs will all be empty"},
+	{Flag: "version-var", Other: "git-version", Require: true, Advice: "the variable is only set with the git version"},
+	{Flag: "lock-wait", Other: "no-lock", Advice: "without a lock there is nothing to wait for"},
+	{Flag: "entrypoint-args", Other: "entrypoint", Require: true, Advice: "replacing the build script's arguments rarely makes sense without a custom entrypoint"},
+}
+
+// Checks the explicitly set command line flags (or their environment variables)
+// against the known incompatibilities, reporting warnings and returning the first
+// fatal violation. Implied flags (e.g. -local for an omitted import path) satisfy
+// the requirements of others, but never conflict with anything.
+func checkFlagRules(implied ...string) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, rule := range flagRules {
+		other := set[rule.Other] || (rule.Require && stringInSlice(rule.Other, implied))
+		if !set[rule.Flag] || other == rule.Require {
+			continue
+		}
+		var err error
+		switch {
+		case rule.Require:
+			err = fmt.Errorf("-%s requires -%s: %s", rule.Flag, rule.Other, rule.Advice)
+		case rule.Fatal:
+			err = fmt.Errorf("-%s cannot be combined with -%s: %s", rule.Flag, rule.Other, rule.Advice)
+		default:
+			err = fmt.Errorf("-%s is not meant to be combined with -%s: %s", rule.Flag, rule.Other, rule.Advice)
+		}
+		if rule.Fatal {
+			return err
+		}
+		warnf("Suspicious flags, %v.", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// End to end self test of docker, the image and its cross toolchains.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Targets the self test builds unless explicitly selected, one per stock toolchain family.
+var selftestTargets = []string{"linux-amd64", "linux-arm", "windows-amd64", "darwin-amd64"}
+
+// Import path the self test programs are mounted at inside the container.
+const selftestRepo = "xgo.local/selftest"
+
+// Self test programs, keyed by their sub-package: a pure Go one exercising the Go
+// cross compiler, and a CGO one exercising the C cross toolchains too.
+var selftestPrograms = map[string]string{
+	"hello": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello from xgo!")
+}
+`,
+	"cgo": `package main
+
+// #include <stdio.h>
+//
+// static void hello() {
+//   printf("Hello from xgo via CGO!\n");
+// }
+import "C"
+
+func main() {
+	C.hello()
+}
+`,
+}
+
+// Order the self test programs are built and reported in.
+var selftestOrder = []string{"hello", "cgo"}
+
+// Cross compiles the bundled self test programs for the selected targets, each into
+// its own scratch folder, and reports per target whether the binaries were produced.
+func runSelftest(image string, config *ConfigFlags, flags *BuildFlags) error {
+	dir, err := os.MkdirTemp("", "xgo-selftest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	sources := filepath.Join(dir, "src")
+	if err := writeSelftestSources(sources); err != nil {
+		return err
+	}
+	targets, _ := getTargets(config.Targets)
+
+	results := make(map[string]map[string]string) // program -> target -> verdict
+	for _, program := range selftestOrder {
+		output := filepath.Join(dir, program)
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return err
+		}
+		test := &ConfigFlags{
+			Repository: selftestRepo,
+			Local:      sources,
+			Package:    program,
+			Targets:    config.Targets,
+			KeepGoing:  true,
+		}
+		fmt.Fprintf(infoOutput, "Self testing the %s program...\n", program)
+		if err := compile(image, test, flags, output); err != nil {
+			debugf("Self test build of %s failed: %v", program, err)
+		}
+		report, err := readBuildReport(output)
+		if err != nil {
+			return err
+		}
+		results[program] = make(map[string]string)
+		for _, target := range targets {
+			verdict := "fail"
+			switch {
+			case program == "cgo" && !cgoEnabled(flags, target):
+				verdict = "skip"
+			case selftestBuilt(output, report, target.Name):
+				verdict = "pass"
+			}
+			results[program][target.Name] = verdict
+		}
+	}
+	// Report the verdicts and fail if any of the targets didn't build
+	var failed int
+
+	fmt.Fprintf(logOutput, "Self test of image %s:\n", image)
+	fmt.Fprintf(logOutput, "  %-24s %-6s %s\n", "target", "go", "cgo")
+	for _, target := range targets {
+		line := fmt.Sprintf("  %-24s", target.Name)
+		for _, program := range selftestOrder {
+			verdict := results[program][target.Name]
+			switch verdict {
+			case "pass":
+				line += " " + paint(colorGreen, fmt.Sprintf("%-6s", verdict))
+			case "fail":
+				line += " " + paint(colorRed, fmt.Sprintf("%-6s", verdict))
+				failed++
+			default:
+				line += " " + fmt.Sprintf("%-6s", verdict)
+			}
+		}
+		fmt.Fprintln(logOutput, line)
+	}
+	if failed > 0 {
+		return buildError(fmt.Errorf("%d of %d builds failed (see the logs above)", failed, len(targets)*len(selftestOrder)))
+	}
+	return nil
+}
+
+// Writes the self test programs into a source folder, one sub-package each.
+func writeSelftestSources(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+selftestRepo+"\n"), 0644); err != nil {
+		return err
+	}
+	for program, source := range selftestPrograms {
+		if err := os.MkdirAll(filepath.Join(dir, program), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, program, "main.go"), []byte(source), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks whether the build report lists a non-empty output for the given target.
+func selftestBuilt(folder string, report *BuildReport, target string) bool {
+	for name, output := range report.Outputs {
+		if output.Target != target {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(folder, name)); err == nil && info.Size() > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Uploading of the produced artifacts into S3 compatible object storage.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Host tool the artifacts are uploaded with, picking up the credentials from the
+// standard AWS environment (variables, profiles or instance roles).
+const uploader = "aws"
+
+// Matcher for an upload destination, a bucket with an optional key prefix.
+var uploadDestRe = regexp.MustCompile(`^s3://[a-z0-9][a-z0-9.-]{1,61}[a-z0-9](/.*)?$`)
+
+// Uploads files of the output folder into the destination bucket and prefix via the
+// aws CLI, returning the URL of every uploaded file keyed by its name. An endpoint
+// may be set for S3 compatible stores other than AWS (e.g. MinIO).
+func uploadArtifacts(dest string, endpoint string, folder string, files []string) (map[string]string, error) {
+	tool, err := runner.LookPath(uploader)
+	if err != nil {
+		return nil, errors.New("aws CLI not found on the host (see https://aws.amazon.com/cli)")
+	}
+	urls := make(map[string]string)
+	for _, name := range files {
+		url := strings.TrimSuffix(dest, "/") + "/" + name
+
+		args := []string{"s3", "cp", "--only-show-errors"}
+		if endpoint != "" {
+			args = append(args, "--endpoint-url", endpoint)
+		}
+		fmt.Fprintf(infoOutput, "Uploading %s to %s...\n", name, url)
+		if err := run(runner.Command(tool, append(args, filepath.Join(folder, name), url)...)); err != nil {
+			return urls, fmt.Errorf("failed to upload %s: %v", name, err)
+		}
+		urls[name] = url
+	}
+	return urls, nil
+}
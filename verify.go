@@ -0,0 +1,96 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Reproducibility verification, rebuilding and comparing the produced artifacts.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Verification is the reproducibility verdict of a single artifact.
+type Verification struct {
+	Name     string // File name of the artifact
+	Target   string // Target the artifact was built for, if known
+	Original string // SHA256 checksum of the artifact from the first build
+	Rebuilt  string // SHA256 checksum of the artifact from the second build (empty = missing)
+}
+
+// Whether the rebuilt artifact is byte identical to the original one.
+func (v *Verification) Reproducible() bool {
+	return v.Original == v.Rebuilt
+}
+
+// Rebuilds the package into a fresh temporary folder with the exact same settings,
+// and compares every artifact of the first build against its rebuilt counterpart.
+func verifyBuild(image string, config *ConfigFlags, flags *BuildFlags, folder string, artifacts []string, report *BuildReport) ([]*Verification, error) {
+	scratch, err := os.MkdirTemp("", "xgo-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+
+	// The rebuild is not part of the served progress, which is already finished
+	defer func(served *BuildProgress) { progress = served }(progress)
+	progress = nil
+
+	fmt.Fprintf(infoOutput, "Rebuilding %s to verify reproducibility...\n", config.Repository)
+	if err := compile(image, config, flags, scratch); err != nil {
+		return nil, fmt.Errorf("rebuild failed: %w", err)
+	}
+	if _, err := readBuildReport(scratch); err != nil {
+		return nil, err
+	}
+	var results []*Verification
+	for _, name := range artifacts {
+		original, err := inspectArtifact(folder, name)
+		if err != nil {
+			return nil, err
+		}
+		result := &Verification{Name: name, Original: original.SHA256}
+		if output, ok := report.Outputs[name]; ok {
+			result.Target = output.Target
+		}
+		if _, err := os.Stat(filepath.Join(scratch, name)); err == nil {
+			rebuilt, err := inspectArtifact(scratch, name)
+			if err != nil {
+				return nil, err
+			}
+			result.Rebuilt = rebuilt.SHA256
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Prints the per artifact verdicts of a reproducibility check, suggesting the usual
+// fixes if any of them differ, and returns the number of non-reproducible ones.
+func printVerification(results []*Verification) int {
+	var failed int
+
+	fmt.Fprintf(logOutput, "Reproducibility check of %d artifact(s):\n", len(results))
+	for _, result := range results {
+		target := result.Target
+		if target == "" {
+			target = "-"
+		}
+		switch {
+		case result.Reproducible():
+			fmt.Fprintf(logOutput, "  %-24s %-40s %s\n", target, result.Name, paint(colorGreen, "reproducible"))
+		case result.Rebuilt == "":
+			fmt.Fprintf(logOutput, "  %-24s %-40s %s\n", target, result.Name, paint(colorRed, "missing from the rebuild"))
+			failed++
+		default:
+			fmt.Fprintf(logOutput, "  %-24s %-40s %s (%.12s != %.12s)\n", target, result.Name, paint(colorRed, "differs"), result.Original, result.Rebuilt)
+			failed++
+		}
+	}
+	if failed > 0 {
+		warnf("Common sources of nondeterminism are embedded paths and build IDs (try -strip-build-id and -- -trimpath), timestamps embedded by C code or dependencies (__DATE__, __TIME__) and compression.")
+	}
+	return failed
+}
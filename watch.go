@@ -0,0 +1,85 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Source tree watcher rebuilding local sources on change.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Interval at which the source tree is polled for changes.
+const watchInterval = 500 * time.Millisecond
+
+// Quiet period to wait for after the last detected change before rebuilding, so
+// that editors saving multiple files (or checkouts) trigger a single build.
+const watchDebounce = time.Second
+
+// Collects the modification time and size of every file in a source tree,
+// skipping the version control metadata folders.
+func scanSources(root string) map[string]string {
+	files := make(map[string]string)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			switch info.Name() {
+			case ".git", ".hg", ".svn", ".bzr":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files[path] = fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+		return nil
+	})
+	return files
+}
+
+// Checks whether two source tree scans differ in any file.
+func sourcesChanged(old, new map[string]string) bool {
+	if len(old) != len(new) {
+		return true
+	}
+	for path, stamp := range new {
+		if old[path] != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// Polls a source tree indefinitely, invoking rebuild after every (debounced)
+// change. The watcher only stops when the process is interrupted.
+func watchSources(root string, rebuild func()) {
+	fmt.Fprintf(infoOutput, "Watching %s for changes, press Ctrl-C to stop...\n", root)
+
+	current := scanSources(root)
+	for {
+		time.Sleep(watchInterval)
+		files := scanSources(root)
+		if !sourcesChanged(current, files) {
+			continue
+		}
+		// Wait for the tree to settle before rebuilding
+		for {
+			time.Sleep(watchDebounce)
+			settled := scanSources(root)
+			if !sourcesChanged(files, settled) {
+				break
+			}
+			files = settled
+		}
+		fmt.Fprintf(infoOutput, "\nSources changed, rebuilding...\n")
+		rebuild()
+
+		// Rescan after the build, the outputs may live within the source tree
+		current = scanSources(root)
+		fmt.Fprintf(infoOutput, "Watching %s for changes, press Ctrl-C to stop...\n", root)
+	}
+}
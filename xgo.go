@@ -8,180 +8,2219 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Cross compilation docker containers
 var dockerBase = "karalabe/xgo-base"
 var dockerDist = "karalabe/xgo-"
 
+// Command line arguments to fine tune the docker invocations
+var dockerHost = flag.String("docker-host", "", "Docker daemon socket to connect to (overrides DOCKER_HOST)")
+var shellComp = flag.String("completion", "", "Print the completion script for a shell (bash, zsh, fish) and exit")
+var entrypoint = flag.String("entrypoint", "", "Custom entrypoint of the build container (advanced, bypasses the xgo build script)")
+var entryArgs = flag.String("entrypoint-args", "", "Whitespace separated arguments to pass to the container instead of the import path")
+var dockerMemory = flag.String("memory", "", "Memory limit of the build container (e.g. 4g, empty = docker default)")
+var dockerNetwork = flag.String("network", "", "Docker network to connect the build container to (e.g. an internal mirror's, empty = docker default)")
+var dockerDNS = stringsVar("dns", "DNS server(s) the build container resolves with, comma separated or repeated (e.g. 10.0.0.2)")
+var dockerDNSSearch = stringsVar("dns-search", "DNS search domain(s) of the build container, comma separated or repeated (e.g. corp.example.com)")
+var containerDir = flag.String("container-build-dir", "/build", "Path the output folder is mounted at inside the build container")
+var useTmpfs = flag.Bool("tmpfs", false, "Keep the intermediate build files of the container in memory (tmpfs), outputs still land on the host")
+var tmpfsSize = flag.String("tmpfs-size", "", "Size limit of the -tmpfs build space (e.g. 2g, empty = docker default)")
+var pullPolicy = flag.String("pull", "missing", "When to pull the build image from the registry (missing, always, never)")
+var forcePullBase = flag.Bool("force-pull-base", false, "Pull the base image ("+dockerBase+") before building, refreshing the layer all images derive from")
+var maxPulls = flag.Int("max-parallel-pulls", 2, "Maximum concurrent image pulls across parallel xgo invocations on the host (0 = unbounded)")
+var rootless = flag.Bool("rootless", false, "Docker daemon runs rootless (e.g. rootless docker or podman), its root already mapping to the invoking user")
+var dockerFlags = stringsVar("docker-flag", "Extra global flag(s) to pass to docker before each subcommand (repeatable)")
+
+// Command line arguments to control the output of xgo itself
+var jsonOutput = flag.Bool("json", false, "Print the build result as JSON on stdout, routing all logs to stderr")
+var githubOutput = flag.Bool("github", false, "Emit GitHub Actions workflow commands (log groups, error and warning annotations)")
+var logLevel = flag.String("log-level", "info", "Verbosity of xgo's own messages (error, warn, info, debug), independent of -v")
+var serveAddr = flag.String("serve", "", "Address to serve the live build progress on as JSON while building (e.g. :8080)")
+var noColor = flag.Bool("no-color", false, "Disable the colors of xgo's own messages on terminals (also via NO_COLOR)")
+var logStderr = flag.Bool("log-stderr", false, "Route all logs, including the container's stdout, to stderr")
+var summaryOnly = flag.Bool("summary-only", false, "Hide the container's output unless the build fails (then only the failed targets' logs), print just the summary")
+var logFile = flag.String("log-file", "", "File to save a copy of the container's output (stdout and stderr) into, besides streaming it")
+
+// Destination of all the human readable logs, including the container's output
+// (stdout, unless routed to stderr to keep stdout clean for machine readable output).
+var logOutput io.Writer = os.Stdout
+
 // Command line arguments to fine tune the compilation
 var goVersion = flag.String("go", "latest", "Go release to use for cross compilation")
+var imageTag = flag.String("image-tag", "", "Full docker image reference to use, overriding the -go based one")
+var listVersions = flag.Bool("list-go-versions", false, "List the Go releases available as images in the registry and exit")
+var imageRepo = flag.String("image-repo", dockerDist, "Docker image name prefix the Go release is appended to (e.g. registry.example.com/xgo:)")
 var inPackage = flag.String("pkg", "", "Sub-package to build if not root import")
+var modRoot = flag.String("module-root", "", "Repository sub-folder holding the Go module, if not the root")
 var outPrefix = flag.String("out", "", "Prefix to use for output naming (empty = package name)")
+var outNames = targetVar("name", "Exact output file names of specific targets as <target>=<file> (e.g. windows-amd64=myapp.exe,linux-arm=myapp-pi)")
+var sourceArchive = flag.String("source-archive", "", "Source archive (.tar.gz, .zip) to build instead of fetching the import path")
+var localSource = flag.String("local", "", "Local source folder to build instead of fetching the import path")
+var watchMode = flag.Bool("watch", false, "Rebuild whenever the -local sources change, polling them for changes (until interrupted)")
 var srcRemote = flag.String("remote", "", "Version control remote repository to build")
 var srcBranch = flag.String("branch", "", "Version control branch to build")
 var crossDeps = flag.String("deps", "", "CGO dependencies (configure/make based archives)")
-var targets   = flag.String("targets", "all", "Specify a comma separated list of targets: linux-amd64,linux-386 linux-arm")
+var provFile = flag.String("provenance", "", "File to write the build provenance metadata into (JSON)")
+var manifestFile = flag.String("manifest", "", "File to write the release manifest of all artifacts into (JSON, e.g. manifest.json)")
+var changedSince = flag.String("changed-since", "", "Previous -manifest to report the new or changed artifacts against (by checksum)")
+var onlyChanged = flag.String("only-changed-targets", "", "Previous -manifest to skip the targets with unchanged inputs (sources, flags, Go release, image) against")
+var changedOut = flag.String("changed-out", "", "Folder to copy the new or changed artifacts into (needs -changed-since)")
+var uploadDest = flag.String("upload", "", "S3 compatible bucket to upload the artifacts (and manifest, provenance) into after a successful build (e.g. s3://bucket/prefix)")
+var uploadEndpoint = flag.String("upload-endpoint", "", "Endpoint of a non-AWS S3 compatible store to -upload into (e.g. https://minio.example.com)")
+var writeLock = flag.String("write-lock", "", "File to write the resolved build inputs into, for exact rebuilds via -from-lock (JSON)")
+var fromLock = flag.String("from-lock", "", "Lockfile to rebuild exactly from, overriding all other flags")
+var verify = flag.Bool("verify", false, "Rebuild into a temporary folder and fail if any artifact isn't byte identical (reproducibility check)")
+var buildIDs = flag.Bool("buildid", false, "Record the Go build ID of each artifact (into -provenance if set)")
+var targets = flag.String("targets", "all", "Comma separated list of targets to build for (e.g. linux-amd64,linux-arm) or all")
+var targetsList = flag.String("targets-file", "", "File listing targets to build for, one per line (# comments allowed), merged with -targets")
+var compress = flag.Bool("compress", false, "Compress the produced binaries with UPX (darwin targets are skipped)")
+var noClobber = flag.Bool("no-clobber", false, "Fail targets whose outputs would overwrite existing files instead of warning afterwards")
+var cleanBuild = flag.Bool("clean-build", false, "Build from scratch, clearing the container's Go caches and rebuilding all packages (-a), slower by design")
+var skipExisting = flag.Bool("skip-existing", false, "Skip targets whose outputs are up to date with the sources and flags")
+var preBuild = flag.String("pre-build", "", "Shell command to run in the container before building (e.g. code generation)")
+var generate = flag.Bool("generate", false, "Run go generate ./... in the container before building")
+var postBuild = flag.String("post-build", "", "Host command to run on each produced artifact (path and target appended)")
+var pkgFormats = flag.String("package", "", "Comma separated system packages to wrap the linux binaries into (deb, rpm)")
+var pkgName = flag.String("pkg-name", "", "Name of the system packages and installed binary (empty = output name)")
+var pkgMaintainer = flag.String("pkg-maintainer", "", "Maintainer of the system packages (e.g. \"Jane Doe <jane@example.com>\")")
+var relVersion = flag.String("version", "", "Version of the release, embedded into the system packages")
+var sourceDate = flag.String("source-date", "", "Unix timestamp to set on all packaged files for reproducibility (defaults to SOURCE_DATE_EPOCH)")
+var noLock = flag.Bool("no-lock", false, "Don't lock the output folder against concurrent xgo builds")
+var lockWait = flag.Bool("lock-wait", false, "Wait for concurrent xgo builds to release the output folder instead of failing")
+var keepGoing = flag.Bool("keep-going", false, "Continue building the remaining targets after one fails")
+var failOnWarning = flag.Bool("fail-on-warning", false, "Fail targets whose C compiler or go vet output contains warnings")
+
+// stringsFlag is a repeatable command line flag collecting all its values.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string     { return strings.Join(*f, " ") }
+func (f *stringsFlag) Set(v string) error { *f = append(*f, v); return nil }
+
+// Defines a repeatable string flag with the specified name and usage string.
+func stringsVar(name string, usage string) *stringsFlag {
+	f := new(stringsFlag)
+	flag.Var(f, name, usage)
+	return f
+}
+
+// Defines a repeatable per target flag whose values are taken verbatim, commas
+// included, repeated ones being joined with the specified separator.
+func targetSepVar(name string, sep string, usage string) *targetFlag {
+	f := &targetFlag{Overrides: make(map[string]string), Sep: sep}
+	flag.Var(f, name, usage)
+	return f
+}
+
+// Returns the environment variable a command line flag falls back to if unset,
+// e.g. XGO_TARGETS for -targets or XGO_DOCKER_HOST for -docker-host.
+func envFlagName(name string) string {
+	return "XGO_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Sets all the command line flags not explicitly specified to the value of their
+// XGO_* environment variable, if present (precedence: flag > env > default).
+func applyEnvFlags() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envFlagName(f.Name)); ok {
+			if err = flag.Set(f.Name, value); err != nil {
+				err = fmt.Errorf("%s: %v", envFlagName(f.Name), err)
+			}
+		}
+	})
+	return err
+}
+
+// Splits the non-flag command line arguments into the positional ones and the
+// ones trailing a `--` terminator, which are passed verbatim to go build. The flag
+// package swallows a terminator preceding all positionals, so it is looked for in
+// the raw arguments too. Positionals looking like flags are rejected, being go
+// build flags that lack the terminator.
+func splitArgs(raw []string, args []string) ([]string, []string, error) {
+	for i := 0; i < len(raw)-len(args); i++ {
+		if raw[i] == "--" {
+			// Everything after a swallowed terminator is meant for go build
+			if len(args) > 0 && looksLikeImportPath(args[len(args)-1]) {
+				return nil, nil, fmt.Errorf("import path %s must precede the -- terminator", args[len(args)-1])
+			}
+			return nil, args, nil
+		}
+		// Skip the value of a flag given as a separate argument (-tags foo)
+		name := strings.TrimLeft(raw[i], "-")
+		if f := flag.Lookup(name); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+			}
+		}
+	}
+	positional, extra := args, []string(nil)
+	for i, arg := range args {
+		if arg == "--" {
+			positional, extra = args[:i], args[i+1:]
+			break
+		}
+	}
+	for _, arg := range positional {
+		if strings.HasPrefix(arg, "-") {
+			return nil, nil, fmt.Errorf("%s is not an import path, go build flags go after the import path and a -- terminator", arg)
+		}
+	}
+	return positional, extra, nil
+}
 
+// Checks whether a command line argument looks like a remote import path (domain
+// first, e.g. github.com/user/repo) rather than a go build flag or its value.
+func looksLikeImportPath(arg string) bool {
+	if strings.HasPrefix(arg, "-") || strings.ContainsAny(arg, "= ") || !strings.Contains(arg, "/") {
+		return false
+	}
+	return strings.Contains(strings.SplitN(arg, "/", 2)[0], ".")
+}
 
+// ConfigFlags is a simple set of flags to define the environment and dependencies.
+type ConfigFlags struct {
+	Repository   string // Root import path to build
+	ModuleRoot   string // Repository sub-folder holding the Go module, if not the root
+	Package      string // Sub-package to build if not root import
+	Local        string // Local source folder to mount instead of fetching
+	Prefix       string // Prefix to use for output naming
+	Remote       string // Version control remote repository to build
+	Branch       string // Version control branch to build
+	Dependencies string // CGO dependencies (configure/make based archives)
+	DepChecksums string // Expected SHA256 checksums of the dependencies, in order
+	PreBuild     string // Shell command to run in the container before building
+	Generate     bool   // Run go generate in the container before building
+	Targets      string // Comma separated list of targets to build for
+	KeepGoing    bool   // Continue building the remaining targets after one fails
+	FailOnWarn   bool   // Fail targets whose build reports any warnings
+	Compress     bool   // Compress the produced binaries with UPX
+	SkipExisting bool   // Skip targets whose outputs are up to date
+}
 
 // Command line arguments to pass to go build
 var buildVerbose = flag.Bool("v", false, "Print the names of packages as they are compiled")
-var buildRace = flag.Bool("race", false, "Enable data race detection (supported only on amd64)")
+var buildRace = flag.Bool("race", false, "Enable data race detection (on the supported targets only)")
+var buildMSan = flag.Bool("msan", false, "Enable interoperation with the C memory sanitizer (on the supported targets only)")
+var buildASan = flag.Bool("asan", false, "Enable interoperation with the C address sanitizer (on the supported targets only)")
+var buildPIE = flag.Bool("pie", false, "Build position independent executables (-buildmode=pie, on the supported targets only)")
+var buildTags = targetVar("tags", "List of build tags to consider satisfied, extendable per target as <target>=<tags> (e.g. linux-arm=softfloat)")
+var buildMatrix = stringsVar("tags-matrix", "Build tag set to build all targets with, once per set, as [<label>=]<tags> (repeatable, e.g. sqlite,fts5)")
+var buildTest = flag.Bool("testbin", false, "Build test binaries (go test -c) instead of executables")
+var buildAMD64 = flag.String("goamd64", "v1", "Microarchitecture level to target on amd64 (v1, v2, v3, v4)")
+var build386 = flag.String("go386", "sse2", "Floating point instruction set to target on 386 (sse2, softfloat)")
+var buildMacOSMin = flag.String("macosx-version-min", "", "Minimum macOS release the darwin binaries support (MACOSX_DEPLOYMENT_TARGET, e.g. 10.6)")
+var buildExperiment = flag.String("goexperiment", "", "Comma separated Go toolchain experiments to enable (GOEXPERIMENT, passed verbatim)")
+var buildGodebug = flag.String("godebug", "", "Comma separated GODEBUG defaults to bake into the binaries (e.g. http2client=0), needs Go 1.23+ modules")
+var buildLdflags = flag.String("ldflags", "", "Arguments to pass on each go tool link invocation")
+var buildStrip = flag.Bool("strip-debug", false, "Strip the symbol table and debug info from the binaries (-ldflags \"-s -w\")")
+var buildStripID = flag.Bool("strip-build-id", false, "Zero out the Go build ID of the binaries for byte-stable outputs (-ldflags \"-buildid=\")")
+var buildLinker = flag.String("linker", "", "External linker to use for CGO binaries on the applicable targets (gold, lld; empty = toolchain default)")
+var gitVersion = flag.Bool("git-version", false, "Inject the git describe version of the sources into the binaries (see -version-var)")
+var versionVar = flag.String("version-var", "main.version", "Fully qualified string variable -git-version injects the version into (-ldflags -X)")
+var buildRpath = flag.String("rpath", "", "Runtime library search path to embed into CGO binaries (e.g. $ORIGIN/lib, not on windows)")
+var buildWinExt = flag.String("windows-ext", "auto", "Extension of the windows outputs (auto = by build mode, none, or explicit like .dll)")
+var buildMode = targetVar("buildmode", "Go build mode, per target as <target>=<mode> (e.g. android-arm=c-shared)")
+var buildCXXFlags = targetSepVar("cgo-cxxflags", " ", "C++ compiler flags for CGO (CGO_CXXFLAGS), per target as <target>=<flags>")
+var buildCPPFlags = targetSepVar("cgo-cppflags", " ", "C preprocessor flags for CGO (CGO_CPPFLAGS), per target as <target>=<flags>")
+var buildCgo = targetVar("cgo", "Whether to enable CGO (true, false), per target as <target>=<bool> (e.g. linux-arm=false)")
+var buildCC = targetVar("cc", "C compiler to use, per target as <target>=<compiler> (e.g. linux-arm=arm-linux-gnueabi-gcc-4.7)")
+var buildEnv = stringsVar("env", "Environment variable of the Go build as NAME=VALUE, per target as <target>:NAME=VALUE (repeatable)")
+
+// Environment variables of the Go build parsed from -env, keyed by target (empty = all).
+var buildEnvs map[string][]string
+
+// BuildFlags is a simple collection of flags to fine tune a build.
+type BuildFlags struct {
+	Verbose  bool        // Print the names of packages as they are compiled
+	Race     bool        // Enable data race detection (on the supported targets only)
+	MSan     bool        // Enable the C memory sanitizer (on the supported targets only)
+	ASan     bool        // Enable the C address sanitizer (on the supported targets only)
+	PIE      bool        // Build position independent executables (on the supported targets only)
+	Tags     *targetFlag // List of build tags to consider satisfied during the build
+	Test     bool        // Build test binaries (go test -c) instead of executables
+	GoAMD64  string      // Microarchitecture level to target on amd64
+	Go386    string      // Floating point instruction set to target on 386
+	MacOSMin string      // Minimum macOS release to target on darwin
+	GoExp    string      // Go toolchain experiments to enable (GOEXPERIMENT)
+	Godebug  string      // GODEBUG defaults to bake into the binaries (go.mod godebug)
+	Args     []string    // Extra arguments to pass verbatim to go build
+	Ldflags  string      // Arguments to pass on each go tool link invocation
+	Version  string      // Variable assignment injecting the version (-X <var>=<version>)
+	Strip    bool        // Strip the symbol table and debug info from the binaries
+	StripID  bool        // Zero out the Go build ID embedded into the binaries
+	Rpath    string      // Runtime library search path to embed into the binaries
+	Linker   string      // External linker to use on the applicable targets (gold, lld)
+	WinExt   string      // Extension policy of the windows outputs (auto, none or explicit)
+	Mode     *targetFlag // Build modes to use instead of the default executables
+	Cgo      *targetFlag // Whether CGO is enabled (defaults to true)
+	CC       *targetFlag // C compilers to use instead of the image defaults
+	CXXFlags *targetFlag // C++ compiler flags for CGO (CGO_CXXFLAGS)
+	CPPFlags *targetFlag // C preprocessor flags for CGO (CGO_CPPFLAGS)
+}
 
 func main() {
+	defer runExitHooks()
 	flag.Parse()
+	if err := applyEnvFlags(); err != nil {
+		fatalf(ErrUsage, "Failed to apply environment flags: %v.", err)
+	}
+	// Rebuild exactly from a lockfile if requested, its flags overriding all others
+	var lock *Lockfile
+	if *fromLock != "" {
+		var err error
+		if lock, err = readLockfile(*fromLock); err != nil {
+			fatalf(ErrSystem, "Failed to read lockfile %s: %v.", *fromLock, err)
+		}
+		if err := applyLockfile(lock); err != nil {
+			fatalf(ErrUsage, "Failed to apply lockfile %s: %v.", *fromLock, err)
+		}
+	}
+	if *jsonOutput || *logStderr || *outPrefix == "-" {
+		logOutput = os.Stderr
+	}
+	if err := setLogLevel(*logLevel); err != nil {
+		fatalf(ErrUsage, "Invalid log level: %v.", err)
+	}
+	if !*noColor && os.Getenv("NO_COLOR") == "" {
+		setColors()
+	}
+	if *logFile != "" {
+		file, err := openLogFile(*logFile)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to open log file %s: %v.", *logFile, err)
+		}
+		defer file.Close()
+		logSink = file
+	}
+
+	// Print the shell completions if requested and exit
+	if *shellComp != "" {
+		script, err := completion(*shellComp)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to generate shell completion: %v.", err)
+		}
+		fmt.Print(script)
+		return
+	}
+	// List the Go releases available in the registry if requested and exit
+	if *listVersions {
+		versions, err := listRemoteVersions(*imageRepo)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to list the Go releases of %s: %v.", *imageRepo, err)
+		}
+		sortVersions(versions)
+		for _, version := range versions {
+			fmt.Println(version)
+		}
+		return
+	}
+	// Validate the command line arguments
+	args, extra, err := splitArgs(os.Args[1:], flag.Args())
+	if err != nil {
+		fatalf(ErrUsage, "Usage: %s [options] <go import path... | info | selftest> [-- go build args] (%v).", os.Args[0], err)
+	}
+	if lock != nil {
+		if len(args) > 0 && args[0] != lock.Repository {
+			warnf("Ignoring import path %s, rebuilding %s from the lockfile.", args[0], lock.Repository)
+		}
+		args, extra = []string{lock.Repository}, lock.Args
+	}
+	// Extract the source archive upfront, its go.mod may be needed for the import path
+	var archiveRoot string
+	if *sourceArchive != "" {
+		dir, root, err := extractSourceArchive(*sourceArchive)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to extract source archive %s: %v.", *sourceArchive, err)
+		}
+		atExit(func() { os.RemoveAll(dir) })
+		archiveRoot = root
+	}
+	var inferLocal bool // Whether the working directory is to be built as the local sources
+	if len(args) == 0 {
+		// No import path given, build the module in the working directory (or archive) if any
+		if archiveRoot != "" {
+			module, err := readModulePath(filepath.Join(archiveRoot, "go.mod"))
+			if err != nil {
+				fatalf(ErrUsage, "Usage: %s [options] <go import path... | info | selftest> [-- go build args] (import path omitted, but no module found in the source archive: %v).", os.Args[0], err)
+			}
+			fmt.Fprintf(infoOutput, "Building module %s from the source archive.\n", module)
+			args = []string{module}
+		} else {
+			module, err := readModulePath("go.mod")
+			if err != nil {
+				fatalf(ErrUsage, "Usage: %s [options] <go import path... | info | selftest> [-- go build args] (import path omitted, but no module found: %v).", os.Args[0], err)
+			}
+			if *srcRemote != "" || *srcBranch != "" {
+				fmt.Fprintf(infoOutput, "Building module %s of the working directory, fetched as requested by -remote or -branch.\n", module)
+			} else {
+				fmt.Fprintf(infoOutput, "Building module %s from the working directory.\n", module)
+				inferLocal = *localSource == ""
+			}
+			args = []string{module}
+		}
+	}
+	for i, arg := range args {
+		if path, ok := importPathFromURL(arg); ok {
+			warnf("Argument %s is a URL, not an import path, building %s instead.", arg, path)
+			args[i] = path
+		}
+	}
+	if len(args) > 1 && (stringInSlice("info", args) || stringInSlice("selftest", args)) {
+		fatalf(ErrUsage, "Usage: %s [options] <go import path... | info | selftest> [-- go build args]", os.Args[0])
+	}
+	if len(args) > 1 {
+		explicit := explicitFlags()
+		for _, name := range singleRepoFlags {
+			if _, ok := explicit[name]; ok {
+				fatalf(ErrUsage, "Invalid flag combination: -%s cannot be combined with multiple import paths, it applies to a single repository.", name)
+			}
+		}
+	}
+	var implied []string
+	if inferLocal {
+		implied = append(implied, "local")
+	}
+	if err := checkFlagRules(implied...); err != nil {
+		fatalf(ErrUsage, "Invalid flag combination: %v.", err)
+	}
+	// Only set the inferred local sources now, the user didn't set -local themselves
+	if inferLocal {
+		flag.Set("local", ".")
+	}
+	if *srcRemote != "" {
+		if err := validateRemote(*srcRemote); err != nil {
+			fatalf(ErrUsage, "Invalid remote repository %s: %v (expected https://host/path, git://host/path, ssh://[user@]host/path or user@host:path).", *srcRemote, err)
+		}
+		if isLocalPath(args[0]) {
+			fatalf(ErrUsage, "Import path %s looks like a local folder, but -remote only switches the origin of a fetched repository: pass the canonical import path the remote belongs to (e.g. github.com/user/repo).", args[0])
+		}
+	}
+	if *localSource != "" {
+		abs, err := filepath.Abs(*localSource)
+		if err != nil {
+			fatalf(ErrSystem, "Invalid local source folder %s: %v.", *localSource, err)
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			fatalf(ErrUsage, "Local source folder %s does not exist or is not a folder.", *localSource)
+		}
+		*localSource = abs
+	}
+	if archiveRoot != "" {
+		*localSource = archiveRoot
+	}
+	// Default to the project's own target set if not explicitly requested
+	_, explicit := explicitFlags()["targets"]
+	if !explicit && *targetsList == "" {
+		list, err := readTargetsFile(targetsFile)
+		switch {
+		case args[0] == "selftest":
+			*targets = strings.Join(selftestTargets, ",")
+		case os.IsNotExist(err):
+			// No project defaults, build the default -targets
+		case err != nil:
+			fatalf(ErrUsage, "Failed to read the default targets from %s: %v.", targetsFile, err)
+		default:
+			fmt.Fprintf(infoOutput, "Using the default targets of %s: %s\n", targetsFile, strings.Join(list, ","))
+			flag.Set("targets", strings.Join(list, ","))
+		}
+	}
+	// Merge in the targets of an explicit targets file, all of which must be known
+	if *targetsList != "" {
+		list, err := readTargetsFile(*targetsList)
+		if err != nil {
+			fatalf(ErrUsage, "Failed to read the targets file %s: %v.", *targetsList, err)
+		}
+		for _, name := range list {
+			if !strings.EqualFold(name, "all") && findTarget(name) == nil {
+				fatalf(ErrUsage, "Unknown target %s in the targets file %s, valid ones are: all, %s.", name, *targetsList, strings.Join(targetNames(), ", "))
+			}
+		}
+		if explicit {
+			list = append([]string{*targets}, list...)
+		}
+		flag.Set("targets", strings.Join(list, ","))
+	}
+	selected, unknown := getTargets(*targets)
+	var skipped []*SkippedTarget // Selected targets not to be built, reported at the end
+	for _, name := range unknown {
+		warnf("Unknown target %s, skipping.", name)
+		skipped = append(skipped, &SkippedTarget{Target: name, Reason: "unknown target"})
+	}
+	if len(selected) == 0 {
+		fatalf(ErrUsage, "No targets selected by -targets=%q, valid ones are: all, %s.", *targets, strings.Join(targetNames(), ", "))
+	}
+	// Streaming the output to stdout is only possible for a single plain artifact
+	toStdout := *outPrefix == "-"
+	if toStdout {
+		if len(selected) != 1 {
+			fatalf(ErrUsage, "Output to stdout needs exactly one target, %d selected (set -targets).", len(selected))
+		}
+		if *jsonOutput || *watchMode {
+			fatalf(ErrUsage, "Output to stdout cannot be combined with -json or -watch, both needing stdout for themselves.")
+		}
+		if len(*buildMatrix) > 0 {
+			fatalf(ErrUsage, "Output to stdout cannot be combined with -tags-matrix, producing an artifact per tag set.")
+		}
+		if *uploadDest != "" {
+			fatalf(ErrUsage, "Output to stdout cannot be combined with -upload, the artifact is not kept.")
+		}
+		*outPrefix = ""
+	}
+	if err := validateOutputPrefix(*outPrefix); err != nil {
+		fatalf(ErrUsage, "Invalid output prefix %s: %v.", *outPrefix, err)
+	}
+	if err := validateOutputNames(outNames); err != nil {
+		fatalf(ErrUsage, "Invalid output names: %v.", err)
+	}
+	if *modRoot != "" {
+		root, err := cleanRelativePath(*modRoot)
+		if err != nil {
+			fatalf(ErrUsage, "Invalid module root %s: %v.", *modRoot, err)
+		}
+		*modRoot = root
+	}
+	if *inPackage != "" {
+		pkg, err := cleanPackagePath(*inPackage)
+		if err != nil {
+			fatalf(ErrUsage, "Invalid sub-package %s: %v.", *inPackage, err)
+		}
+		*inPackage = pkg
+	}
+	if !stringInSlice(*buildAMD64, []string{"v1", "v2", "v3", "v4"}) {
+		fatalf(ErrUsage, "Invalid amd64 microarchitecture level: %s (must be v1, v2, v3 or v4).", *buildAMD64)
+	}
+	if !stringInSlice(*build386, []string{"sse2", "softfloat"}) {
+		fatalf(ErrUsage, "Invalid 386 floating point mode: %s (must be sse2 or softfloat).", *build386)
+	}
+	if _, ok := explicitFlags()["goexperiment"]; ok && strings.TrimSpace(*buildExperiment) == "" {
+		fatalf(ErrUsage, "Invalid Go experiments: -goexperiment must not be empty when set.")
+	}
+	for _, setting := range splitList([]string{*buildGodebug}) {
+		if !godebugRe.MatchString(setting) {
+			fatalf(ErrUsage, "Invalid GODEBUG default: %s (must be a key=value setting like http2client=0).", setting)
+		}
+	}
+	if !path.IsAbs(*containerDir) || path.Clean(*containerDir) == "/" {
+		fatalf(ErrUsage, "Invalid container build folder: %s (must be an absolute path other than /).", *containerDir)
+	}
+	sets, err := parseTagsMatrix(*buildMatrix)
+	if err != nil {
+		fatalf(ErrUsage, "Invalid tags matrix: %v.", err)
+	}
+	tagSets = sets
 
+	envs, err := parseBuildEnv(*buildEnv)
+	if err != nil {
+		fatalf(ErrUsage, "Invalid build environment: %v.", err)
+	}
+	buildEnvs = envs
+
+	if err := checkCgoFlag(buildCgo); err != nil {
+		fatalf(ErrUsage, "Invalid CGO setting: %v.", err)
+	}
+	if *buildMacOSMin != "" && !macOSVersionRe.MatchString(*buildMacOSMin) {
+		fatalf(ErrUsage, "Invalid minimum macOS release: %s (must be like 10.6 or 10.6.8).", *buildMacOSMin)
+	}
+	if *buildWinExt != "auto" && *buildWinExt != "none" && !windowsExtRe.MatchString(*buildWinExt) {
+		fatalf(ErrUsage, "Invalid windows extension: %s (must be auto, none or an extension like .dll).", *buildWinExt)
+	}
+	if strings.Contains(*buildRpath, "'") && strings.Contains(*buildRpath, `"`) {
+		fatalf(ErrUsage, "Invalid runtime library path: %s (must not contain both single and double quotes, the Go linker flags can't escape them).", *buildRpath)
+	}
+	if _, ok := linkerPlatforms[*buildLinker]; !ok && *buildLinker != "" {
+		fatalf(ErrUsage, "Invalid linker: %s (must be gold or lld).", *buildLinker)
+	}
+	if *dockerNetwork != "" {
+		if !dockerNetworkRe.MatchString(*dockerNetwork) {
+			fatalf(ErrUsage, "Invalid docker network: %s (must be a network name or ID, host, none or container:<name>).", *dockerNetwork)
+		}
+		if *dockerNetwork == "host" {
+			warnf("Building on the host network: the container, and any code run during the build, can reach all services of the host.")
+		}
+	}
+	for _, server := range splitList(*dockerDNS) {
+		if net.ParseIP(server) == nil {
+			fatalf(ErrUsage, "Invalid DNS server: %s (must be an IP address).", server)
+		}
+	}
+	for _, domain := range splitList(*dockerDNSSearch) {
+		if !dnsDomainRe.MatchString(domain) {
+			fatalf(ErrUsage, "Invalid DNS search domain: %s (must be a domain name like corp.example.com, or . for none).", domain)
+		}
+	}
+	if *uploadDest != "" && !uploadDestRe.MatchString(*uploadDest) {
+		fatalf(ErrUsage, "Invalid upload destination: %s (must be like s3://bucket or s3://bucket/prefix).", *uploadDest)
+	}
+	if !versionVarRe.MatchString(*versionVar) {
+		fatalf(ErrUsage, "Invalid version variable: %s (must be a fully qualified name like main.version or github.com/user/repo/pkg.Version).", *versionVar)
+	}
+	if *sourceDate == "" {
+		*sourceDate = os.Getenv("SOURCE_DATE_EPOCH")
+	}
+	if *sourceDate != "" {
+		if secs, err := strconv.ParseInt(*sourceDate, 10, 64); err != nil || secs < 0 {
+			fatalf(ErrUsage, "Invalid source date: %s (must be a unix timestamp, e.g. the last commit's: git log -1 --format=%%ct).", *sourceDate)
+		}
+	}
+	if !pullPolicies[*pullPolicy] {
+		fatalf(ErrUsage, "Invalid pull policy: %s (must be missing, always or never).", *pullPolicy)
+	}
+	if *forcePullBase && *pullPolicy == "never" {
+		fatalf(ErrUsage, "Invalid flag combination: -force-pull-base cannot be combined with -pull=never, pulling is disabled.")
+	}
+	if *pkgFormats != "" {
+		for _, format := range strings.Split(*pkgFormats, ",") {
+			if !packageFormats[format] {
+				fatalf(ErrUsage, "Invalid system package format: %s (must be deb or rpm).", format)
+			}
+		}
+	}
 	// Ensure docker is available
+	if host := remoteDockerHost(); host != "" {
+		warnf("Using remote docker daemon %s, the working directory must exist on its host too.", host)
+	}
 	if err := checkDocker(); err != nil {
-		log.Fatalf("Failed to check docker installation: %v.", err)
+		fatalf(ErrSystem, "Failed to check docker installation: %v.", err)
 	}
-	// Validate the command line arguments
-	if len(flag.Args()) != 1 {
-		log.Fatalf("Usage: %s [options] <go import path>", os.Args[0])
+	if *dockerNetwork != "" && !dockerVersionAtLeast(dockerNetworkVersion) {
+		fatalf(ErrSystem, "Docker %s is too old for -network, user defined networks need docker %s or newer.", dockerVersion, dockerNetworkVersion)
+	}
+	if *useTmpfs && !dockerVersionAtLeast(dockerTmpfsVersion) {
+		fatalf(ErrSystem, "Docker %s is too old for -tmpfs, tmpfs mounts need docker %s or newer.", dockerVersion, dockerTmpfsVersion)
 	}
 	// Check that all required images are available
-	found, err := checkDockerImage(dockerDist + *goVersion)
-	switch {
-	case err != nil:
-		log.Fatalf("Failed to check docker image availability: %v.", err)
-	case !found:
-		fmt.Println("not found!")
-		if err := pullDockerImage(dockerDist + *goVersion); err != nil {
-			log.Fatalf("Failed to pull docker image from the registry: %v.", err)
+	if *goVersion == "gotip" {
+		*goVersion = "tip"
+	}
+	if isVersionPattern(*goVersion) && *imageTag == "" {
+		version, err := resolveGoVersion(*goVersion)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to resolve Go release %s: %v.", *goVersion, err)
 		}
-	default:
-		fmt.Println("found.")
+		fmt.Fprintf(infoOutput, "Resolved Go release %s to %s.\n", *goVersion, version)
+		*goVersion = version
+	}
+	if *goVersion == "tip" && *imageTag == "" {
+		warnf("Building with the Go development tip: results are not reproducible and CGO support is experimental.")
+	}
+	image := dockerImage()
+
+	// Refresh the base layer of the images first if requested
+	if *forcePullBase {
+		if err := pullDockerImage(dockerBase); err != nil {
+			fatalf(ErrSystem, "Failed to pull base image %s: %v.", dockerBase, err)
+		}
+	}
+	if err := ensureDockerImage(image, *pullPolicy); err != nil {
+		fatalf(ErrSystem, "Failed to prepare docker image %s: %v.", image, err)
+	}
+	// Ensure a locked build gets the exact same image, tags may have been moved
+	if lock != nil && lock.ImageID != "" {
+		id, err := inspectDockerImage(image)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to inspect docker image: %v.", err)
+		}
+		if id != lock.ImageID {
+			fatalf(ErrSystem, "Docker image %s is %s, but the lockfile expects %s.", image, id, lock.ImageID)
+		}
+	}
+	// Report the capabilities of the image instead of building if requested
+	if args[0] == "info" {
+		info, err := inspectImage(image)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to inspect docker image: %v.", err)
+		}
+		if err := printImageInfo(image, info); err != nil {
+			fatalf(ErrSystem, "Failed to print image info: %v.", err)
+		}
+		return
 	}
 	// Cross compile the requested package into the local folder
-	if err := compile(flag.Args()[0], *srcRemote, *srcBranch, *inPackage, *targets, *crossDeps, *outPrefix, *buildVerbose, *buildRace); err != nil {
-		log.Fatalf("Failed to cross compile package: %v.", err)
+	config := &ConfigFlags{
+		Repository:   args[0],
+		ModuleRoot:   *modRoot,
+		Package:      *inPackage,
+		Local:        *localSource,
+		Prefix:       *outPrefix,
+		Remote:       *srcRemote,
+		Branch:       *srcBranch,
+		Dependencies: *crossDeps,
+		PreBuild:     *preBuild,
+		Generate:     *generate,
+		Targets:      *targets,
+		KeepGoing:    *keepGoing,
+		FailOnWarn:   *failOnWarning,
+		Compress:     *compress,
+		SkipExisting: *skipExisting,
 	}
-}
+	flags := &BuildFlags{
+		Verbose:  *buildVerbose,
+		Race:     *buildRace,
+		MSan:     *buildMSan,
+		ASan:     *buildASan,
+		PIE:      *buildPIE,
+		Tags:     buildTags,
+		Test:     *buildTest,
+		GoAMD64:  *buildAMD64,
+		Go386:    *build386,
+		MacOSMin: *buildMacOSMin,
+		GoExp:    *buildExperiment,
+		Godebug:  strings.Join(splitList([]string{*buildGodebug}), ","),
+		Args:     extra,
+		Ldflags:  *buildLdflags,
+		Strip:    *buildStrip,
+		StripID:  *buildStripID,
+		Rpath:    *buildRpath,
+		Linker:   *buildLinker,
+		WinExt:   *buildWinExt,
+		Mode:     buildMode,
+		Cgo:      buildCgo,
+		CC:       buildCC,
+		CXXFlags: buildCXXFlags,
+		CPPFlags: buildCPPFlags,
+	}
+	if lock != nil {
+		config.DepChecksums = lockedChecksums(lock, config.Dependencies)
+	}
+	// Inject the version of the sources into the binaries if requested
+	if *gitVersion {
+		dir := *localSource
+		if dir == "" {
+			dir = "."
+		}
+		if version, err := describeGitVersion(dir); err != nil {
+			warnf("Failed to describe the git version of %s, building without: %v.", dir, err)
+		} else {
+			fmt.Fprintf(infoOutput, "Injecting version %s into %s.\n", version, *versionVar)
+			flags.Version = *versionVar + "=" + version
+		}
+	}
+	// Skip the targets the image lacks a toolchain for, instead of failing cryptically
+	if info, err := inspectImage(image); err != nil {
+		debugf("Skipping the toolchain check, image capabilities unavailable: %v", err)
+		if selectsAll(config.Targets) {
+			warnf("Image %s doesn't advertise its capabilities, some of the targets selected by all may fail.", image)
+		}
+	} else {
+		var unsupported []*SkippedTarget
+		selected, unsupported = supportedTargets(info, selected, flags)
+		if missing := missingLinkerTargets(info, flags, selected); len(missing) > 0 {
+			warnf("Image %s doesn't provide the %s linker (ld.%s) for %s, linking with it may fail (see %s info).", image, flags.Linker, flags.Linker, strings.Join(missing, ", "), os.Args[0])
+		}
+		if selectsAll(config.Targets) && len(unsupported) > 0 {
+			warnf("Image %s only supports %d of the %d targets selected by all, skipping %s.", image, len(selected), len(selected)+len(unsupported), joinSkipped(unsupported))
+		} else {
+			for _, target := range unsupported {
+				warnf("Image %s doesn't support %s, skipping.", image, target)
+			}
+		}
+		for _, skip := range unsupported {
+			if target := findTarget(skip.Target); target != nil && target.Musl && cgoEnabled(flags, target) {
+				fatalf(ErrUsage, "Image %s can't build %s: %s (musl targets need a custom image with the musl cross toolchains, or -cgo=%s=false).", image, skip.Target, skip.Reason, skip.Target)
+			}
+		}
+		skipped = append(skipped, unsupported...)
+		if len(selected) == 0 {
+			fatalf(ErrUsage, "None of the selected targets are supported by image %s (see %s info).", image, os.Args[0])
+		}
+		names := make([]string, len(selected))
+		for i, target := range selected {
+			names[i] = target.Name
+		}
+		config.Targets = strings.Join(names, ",")
+	}
+	// Cross compile the bundled self test programs instead if requested
+	if args[0] == "selftest" {
+		if err := runSelftest(image, config, flags); err != nil {
+			fatalf(failureKind(err), "Self test failed: %v.", err)
+		}
+		return
+	}
+	folder, err := os.Getwd()
+	if err != nil {
+		fatalf(ErrSystem, "Failed to retrieve the working directory: %v.", err)
+	}
+	if toStdout {
+		if folder, err = os.MkdirTemp("", "xgo-"); err != nil {
+			fatalf(ErrSystem, "Failed to create the scratch output folder: %v.", err)
+		}
+		scratch := folder
+		atExit(func() { os.RemoveAll(scratch) })
+	}
+	if !*noLock && !toStdout {
+		unlock, err := lockOutput(folder, *lockWait)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to lock the output folder %s: %v (wait via -lock-wait, or disable via -no-lock).", folder, err)
+		}
+		defer unlock()
+	}
+	// Load the previous manifest before building, as it may be overwritten by -manifest
+	var previous *Manifest
+	if *changedSince != "" {
+		if previous, err = readManifest(*changedSince); err != nil {
+			fatalf(ErrSystem, "Failed to read the previous manifest: %v.", err)
+		}
+		if previous == nil {
+			warnf("Previous manifest %s not found, treating all artifacts as changed.", *changedSince)
+		}
+	}
+	if *onlyChanged != "" {
+		if baseline, err = readManifest(*onlyChanged); err != nil {
+			fatalf(ErrSystem, "Failed to read the baseline manifest: %v.", err)
+		}
+		if baseline == nil {
+			warnf("Baseline manifest %s not found, building all targets.", *onlyChanged)
+		}
+		if imageDigest, err = inspectDockerImage(image); err != nil {
+			fatalf(ErrSystem, "Failed to inspect docker image: %v.", err)
+		}
+	}
+	before, err := snapshotDir(folder)
+	if err != nil {
+		fatalf(ErrSystem, "Failed to snapshot the output folder: %v.", err)
+	}
+	// Build every requested repository with the same flags, aggregating the reports
+	started := time.Now()
+	report := &BuildReport{Outputs: make(map[string]*TargetReport), Skipped: skipped}
 
-// Checks whether a docker installation can be found and is functional.
-func checkDocker() error {
-	fmt.Println("Checking docker installation...")
-	if err := run(exec.Command("docker", "version")); err != nil {
-		return err
+	if *serveAddr != "" {
+		progress = new(BuildProgress)
+		stop, err := serveProgress(*serveAddr, progress)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to serve the build progress: %v.", err)
+		}
+		defer stop()
+		fmt.Fprintf(infoOutput, "Serving the build progress on %s.\n", *serveAddr)
+	}
+	var (
+		failed []string
+		kind   = ErrBuild // Class of the repository failures, system if any wasn't a build one
+		builds = len(args)
+		noun   = "repositories"
+	)
+	// Build every tag set of the matrix separately if requested, default flags otherwise
+	variants := []*TagSet{nil}
+	if len(tagSets) > 0 {
+		variants, builds, noun = tagSets, len(args)*len(tagSets), "builds"
+	}
+	for _, repo := range args {
+		config.Repository = repo
+		for _, set := range variants {
+			name, variant, variantFlags := repo, config, flags
+			if set != nil {
+				name = repo + " [" + set.Label + "]"
+				variant, variantFlags = tagSetBuild(config, flags, set)
+				fmt.Fprintf(infoOutput, "Building tag set %s (tags: %s)...\n", set.Label, joinTags(variantFlags.Tags.Default))
+			}
+			err := compile(image, variant, variantFlags, folder)
+			if progress != nil {
+				progress.end(err)
+			}
+			if err != nil {
+				if builds == 1 && !*watchMode {
+					fatalf(failureKind(err), "Failed to cross compile package: %v.", err)
+				}
+				log.Printf("Failed to cross compile %s: %v.", name, err)
+				failed = append(failed, name)
+				if failureKind(err) != ErrBuild {
+					kind = ErrSystem
+				}
+			}
+			part, err := readBuildReport(folder)
+			if err != nil {
+				fatalf(ErrSystem, "Failed to read the build report: %v.", err)
+			}
+			report.merge(part)
+		}
+	}
+	if progress != nil {
+		progress.finish(len(failed) > 0)
+	}
+	// Gather the produced artifacts and report on them
+	after, err := snapshotDir(folder)
+	if err != nil {
+		fatalf(ErrSystem, "Failed to snapshot the output folder: %v.", err)
+	}
+	artifacts := newArtifacts(before, after)
+	if !config.SkipExisting {
+		if clobbered := clobberedArtifacts(before, artifacts); len(clobbered) > 0 {
+			warnf("Overwrote %d existing file(s) in the output folder: %s (use -no-clobber to fail instead).", len(clobbered), strings.Join(clobbered, ", "))
+		}
+	}
+	printSummary(folder, artifacts, report, time.Since(started))
+
+	// Publish nothing of a partly failed run, the manifest, provenance, lockfile, hooks
+	// and uploads would all miss the failed builds while looking complete
+	if len(failed) > 0 {
+		if !*watchMode {
+			fatalf(kind, "Failed to cross compile %d of %d %s: %s.", len(failed), builds, noun, strings.Join(failed, ", "))
+		}
+		// Keep watching after a failed initial build, the fix is what's being waited for
+		watchBuilds(image, config, flags, folder)
+		return
+	}
+	// Rebuild and compare the artifacts if reproducibility is to be verified
+	if *verify {
+		results, err := verifyBuild(image, config, flags, folder, artifacts, report)
+		if err != nil {
+			fatalf(failureKind(err), "Failed to verify reproducibility: %v.", err)
+		}
+		if failed := printVerification(results); failed > 0 {
+			fatalf(ErrBuild, "Failed to reproduce %d of %d artifact(s).", failed, len(results))
+		}
+	}
+
+	if *provFile != "" {
+		if err := writeProvenance(*provFile, image, config, folder, artifacts, report, started); err != nil {
+			fatalf(ErrSystem, "Failed to write build provenance: %v.", err)
+		}
+	} else if *buildIDs {
+		for _, name := range artifacts {
+			fmt.Fprintf(logOutput, "Build ID of %s: %s\n", name, readBuildID(filepath.Join(folder, name)))
+		}
+	}
+	if *manifestFile != "" {
+		if err := writeManifest(*manifestFile, image, config, folder, artifacts, report, started); err != nil {
+			fatalf(ErrSystem, "Failed to write the release manifest: %v.", err)
+		}
+	}
+	if *writeLock != "" {
+		if err := writeLockfile(*writeLock, image, config, extra, report); err != nil {
+			fatalf(ErrSystem, "Failed to write the lockfile: %v.", err)
+		}
+	}
+	if *changedSince != "" {
+		changed, err := changedArtifacts(previous, folder, artifacts)
+		if err != nil {
+			fatalf(ErrSystem, "Failed to compare against the previous manifest: %v.", err)
+		}
+		fmt.Fprintf(logOutput, "Changed artifacts since %s: %d of %d\n", *changedSince, len(changed), len(artifacts))
+		for _, name := range changed {
+			fmt.Fprintf(logOutput, "  %s\n", name)
+		}
+		if *changedOut != "" {
+			if err := copyArtifacts(folder, changed, *changedOut); err != nil {
+				fatalf(ErrSystem, "Failed to copy the changed artifacts: %v.", err)
+			}
+		}
+	}
+	// Run the post build hooks on the artifacts if requested
+	if *postBuild != "" {
+		if err := runPostBuildHooks(*postBuild, folder, artifacts, report); err != nil {
+			fatalf(ErrSystem, "Failed to run post build hooks: %v.", err)
+		}
+	}
+	// Wrap the linux binaries into system packages if requested
+	if *pkgFormats != "" {
+		info := &PackageInfo{
+			Formats:    strings.Split(*pkgFormats, ","),
+			Name:       *pkgName,
+			Version:    *relVersion,
+			Maintainer: *pkgMaintainer,
+			Epoch:      *sourceDate,
+		}
+		if info.Name == "" {
+			info.Name = outputName(config)
+		}
+		if err := buildPackages(info, folder, artifacts, report); err != nil {
+			fatalf(ErrSystem, "Failed to build system packages: %v.", err)
+		}
+	}
+	// Upload the artifacts and the release metadata if requested
+	var urls map[string]string
+	if *uploadDest != "" {
+		if urls, err = uploadArtifacts(*uploadDest, *uploadEndpoint, folder, artifacts); err != nil {
+			fatalf(ErrSystem, "Failed to upload the artifacts: %v.", err)
+		}
+		for _, file := range []string{*manifestFile, *provFile} {
+			if file == "" {
+				continue
+			}
+			if _, err := uploadArtifacts(*uploadDest, *uploadEndpoint, filepath.Dir(file), []string{filepath.Base(file)}); err != nil {
+				fatalf(ErrSystem, "Failed to upload the release metadata: %v.", err)
+			}
+		}
+	}
+	if *jsonOutput {
+		if err := printResult(image, folder, artifacts, report, urls, time.Since(started)); err != nil {
+			fatalf(ErrSystem, "Failed to print the build result: %v.", err)
+		}
+	}
+	// Stream the artifact out of the scratch folder if requested
+	if toStdout {
+		if err := streamArtifact(folder, artifacts); err != nil {
+			fatalf(ErrSystem, "Failed to stream the artifact to stdout: %v.", err)
+		}
+	}
+	// Keep rebuilding on source changes if requested
+	if *watchMode {
+		watchBuilds(image, config, flags, folder)
 	}
-	fmt.Println()
-	return nil
 }
 
-// Checks whether a required docker image is available locally.
-func checkDockerImage(image string) (bool, error) {
-	fmt.Printf("Checking for required docker image %s... ", image)
-	out, err := exec.Command("docker", "images", "--no-trunc").Output()
-	if err != nil {
-		return false, err
+// Rebuilds the package into the output folder whenever the local sources change,
+// reporting on every build but never exiting on failures.
+func watchBuilds(image string, config *ConfigFlags, flags *BuildFlags, folder string) {
+	watchSources(*localSource, func() {
+		before, _ := snapshotDir(folder)
+		started := time.Now()
+		if err := compile(image, config, flags, folder); err != nil {
+			log.Printf("Failed to cross compile package: %v.", err)
+		}
+		after, _ := snapshotDir(folder)
+		report, err := readBuildReport(folder)
+		if err != nil {
+			log.Printf("Failed to read the build report: %v.", err)
+			return
+		}
+		printSummary(folder, newArtifacts(before, after), report, time.Since(started))
+	})
+}
+
+// Creates a docker command invoking the given subcommand, injecting any user
+// specified global docker flags in front of it.
+func dockerCommand(args ...string) *exec.Cmd {
+	var global []string
+	if *dockerHost != "" {
+		global = append(global, "-H", *dockerHost)
 	}
-	return bytes.Contains(out, []byte(image)), nil
+	for _, f := range *dockerFlags {
+		global = append(global, strings.Fields(f)...)
+	}
+	cmd := runner.Command("docker", append(global, args...)...)
+	debugf("Running %s", strings.Join(cmd.Args, " "))
+	return cmd
 }
 
-// Pulls an image from the docker registry.
-func pullDockerImage(image string) error {
-	fmt.Printf("Pulling %s from docker registry...\n", image)
-	return run(exec.Command("docker", "pull", image))
+// Returns the docker daemon address if it's not a local socket, either set via
+// the command line or the standard DOCKER_HOST environment variable.
+func remoteDockerHost() string {
+	host := *dockerHost
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" || strings.HasPrefix(host, "unix://") || strings.HasPrefix(host, "npipe://") {
+		return ""
+	}
+	return host
 }
 
-// Checks if a string is in the array
-func stringInSlice(a string, list []string) bool {
-	for _, b := range list {
-		if b == a {
-			return true
-		}
+// Assembles the docker image reference to cross compile with, either the one
+// explicitly pinned by the user, or the one matching the requested Go release
+// within the configured image repository.
+func dockerImage() string {
+	if *imageTag != "" {
+		return *imageTag
 	}
-	return false
+	return *imageRepo + *goVersion
 }
 
-// Check which targets to compile for
-func getTargets(targets string) (linux64 string, linux386 string, linuxArm string, windows64 string, windows386 string, darwin64 string, darwin386 string) {
+// Oldest docker release known to support everything xgo relies on (the image
+// management commands), older ones only getting by with fallbacks.
+const minDockerVersion = "1.13.0"
+
+// Docker releases introducing the features xgo only uses when requested.
+const (
+	dockerNetworkVersion       = "1.9.0"  // User defined networks (docker run --net)
+	dockerTmpfsVersion         = "1.10.0" // In-memory mounts (docker run --tmpfs)
+	dockerNetworkOptionVersion = "1.12.0" // Renaming of docker run --net to --network
+	dockerImageVersion         = "1.13.0" // Management commands (docker image inspect)
+)
+
+// Version of the docker client, as detected during the installation check.
+var dockerVersion string
 
-	// Targets
-	linux64 = "false"
-	linux386 = "false"
-	linuxArm = "false"
-	windows64 = "false"
-	windows386 = "false"
-	darwin64 = "false"
-	darwin386 = "false"
+// Content digest of the build image, fingerprinted into the target inputs by
+// -only-changed-targets.
+var imageDigest string
+
+// Matcher for the client version in the output of `docker version`, handling
+// both the old (Client version: x) and the new (Version: x) formats.
+var dockerVersionRe = regexp.MustCompile(`(?m)^\s*(?:Client version|Version):\s*v?(\d+(?:\.\d+)*)`)
+
+// Checks whether a docker installation can be found and is functional.
+func checkDocker() error {
+	fmt.Fprintln(infoOutput, "Checking docker installation...")
+	cmd := dockerCommand("version")
+	cmd.Stderr = os.Stderr
 
-	if targets != "all" {
+	out, err := runner.Output(cmd)
+	infoOutput.Write(out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(infoOutput)
 
-		if stringInSlice("linux64", strings.Split(targets, ",")) {
-			linux64 = "true"
+	// Record the client version and warn if it's known to be too old
+	if match := dockerVersionRe.FindSubmatch(out); match != nil {
+		dockerVersion = string(match[1])
+		if !dockerVersionAtLeast(minDockerVersion) {
+			warnf("Docker %s is older than the minimum known good %s, some xgo features may fail.", dockerVersion, minDockerVersion)
 		}
-		if stringInSlice("linux386", strings.Split(targets, ",")) {
-			linux386 = "true"
+	} else {
+		warnf("Failed to detect the docker version, assuming compatibility.")
+	}
+	return nil
+}
+
+// Checks whether the detected docker client is at least of the given version.
+// An undetected version is assumed to be compatible.
+func dockerVersionAtLeast(min string) bool {
+	if dockerVersion == "" {
+		return true
+	}
+	have, want := strings.Split(dockerVersion, "."), strings.Split(min, ".")
+	for i := 0; i < len(want); i++ {
+		var h, w int
+		if i < len(have) {
+			h, _ = strconv.Atoi(have[i])
 		}
-		if stringInSlice("linuxArm", strings.Split(targets, ",")) {
-			linuxArm = "true"
+		w, _ = strconv.Atoi(want[i])
+		if h != w {
+			return h > w
 		}
-		if stringInSlice("windows64", strings.Split(targets, ",")) {
-			windows64 = "true"
+	}
+	return true
+}
+
+// Matcher for the name of an environment variable.
+var envNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Parses the -env entries into per target lists of NAME=VALUE assignments, keyed
+// by target name (empty for the entries applying to all targets). The variables
+// defining the target itself are rejected, they'd silently build something else.
+func parseBuildEnv(entries []string) (map[string][]string, error) {
+	envs := make(map[string][]string)
+	for _, entry := range entries {
+		target, assign := "", entry
+		if eq := strings.Index(entry, "="); eq > 0 {
+			if colon := strings.Index(entry[:eq], ":"); colon >= 0 {
+				known := findTarget(entry[:colon])
+				if known == nil {
+					return nil, fmt.Errorf("unknown target %s in %s", entry[:colon], entry)
+				}
+				target, assign = known.Name, entry[colon+1:]
+			}
 		}
-		if stringInSlice("windows386", strings.Split(targets, ",")) {
-			windows386 = "true"
+		eq := strings.Index(assign, "=")
+		if eq < 0 || !envNameRe.MatchString(assign[:eq]) {
+			return nil, fmt.Errorf("%s is not a NAME=VALUE assignment (optionally prefixed with <target>:)", entry)
 		}
-		if stringInSlice("darwin64", strings.Split(targets, ",")) {
-			darwin64 = "true"
+		if strings.Contains(assign, "\n") {
+			return nil, fmt.Errorf("%s contains a newline", assign[:eq])
 		}
-		if stringInSlice("darwin386", strings.Split(targets, ",")) {
-			darwin386 = "true"
+		switch assign[:eq] {
+		case "GOOS", "GOARCH":
+			return nil, fmt.Errorf("%s is set by the target, select targets via -targets instead", assign[:eq])
+		case "CGO_ENABLED":
+			return nil, fmt.Errorf("%s is set by the target, toggle it via -cgo instead", assign[:eq])
 		}
-	} else {
-		fmt.Printf("Building for all arch")
-		linux64 = "true"
-		linux386 = "true"
-		linuxArm = "true"
-		windows64 = "true"
-		windows386 = "true"
-		darwin64 = "true"
-		darwin386 = "true"
+		envs[target] = append(envs[target], assign)
 	}
-	return linux64, linux386, linuxArm, windows64, windows386, darwin64, darwin386
+	return envs, nil
 }
 
-// Cross compiles a requested package into the current working directory.
-func compile(repo string, remote string, branch string, pack string, targets string, deps string, prefix string, verbose bool, race bool) error {
-	folder, err := os.Getwd()
+// Returns the environment assignments of the Go build of a target: the global ones
+// first, then the target's own, replacing any global one of the same name.
+func targetBuildEnv(envs map[string][]string, target string) []string {
+	names := make(map[string]bool)
+	for _, assign := range envs[target] {
+		names[assign[:strings.Index(assign, "=")]] = true
+	}
+	var result []string
+	for _, assign := range envs[""] {
+		if !names[assign[:strings.Index(assign, "=")]] {
+			result = append(result, assign)
+		}
+	}
+	return append(result, envs[target]...)
+}
+
+// Matcher for a docker network name, ID or container:<name> mode.
+var dockerNetworkRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*(:[a-zA-Z0-9][a-zA-Z0-9_.-]*)?$`)
+
+// Matcher for a DNS search domain, or the lone dot disabling the search.
+var dnsDomainRe = regexp.MustCompile(`^(\.|[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*\.?)$`)
+
+// Splits the values of a repeatable flag further at commas, dropping empty items.
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// Matcher for a fully qualified Go variable (import path, dot, identifier).
+var versionVarRe = regexp.MustCompile(`^[^\s=]+\.[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Describes the checked out revision of a git repository via the closest tag (e.g.
+// v1.2.3-4-gabcdef0-dirty), falling back to the abbreviated commit hash if there
+// are no tags.
+func describeGitVersion(dir string) (string, error) {
+	out, err := runner.Output(runner.Command("git", "-C", dir, "describe", "--tags", "--always", "--dirty"))
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+			return "", errors.New(strings.TrimSpace(string(exit.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Valid values of the -pull image pull policy.
+var pullPolicies = map[string]bool{"missing": true, "always": true, "never": true}
+
+// Makes the build image available according to the pull policy: pulling it only if
+// not available locally (missing), unconditionally (always), or failing instead of
+// pulling (never).
+func ensureDockerImage(image string, policy string) error {
+	if policy == "always" {
+		return pullDockerImage(image)
+	}
+	found, err := checkDockerImage(image)
+	switch {
+	case err != nil:
+		return fmt.Errorf("failed to check local images: %v", err)
+	case found:
+		debugf("Docker image %s found locally", image)
+		return nil
+	case policy == "never":
+		return errors.New("not available locally, and pulling is disabled by -pull=never")
+	default:
+		return pullDockerImage(image)
+	}
+}
+
+// Checks whether a required docker image is available locally. The image is
+// inspected directly, as listing all the images is slow on large image stores,
+// falling back to the listing only on ancient docker clients lacking the command.
+func checkDockerImage(image string) (bool, error) {
+	if dockerVersionAtLeast(dockerImageVersion) {
+		_, err := runner.Output(dockerCommand("image", "inspect", "--format", "{{.Id}}", image))
+		if err == nil {
+			return true, nil
+		}
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			return false, err
+		}
+		stderr := strings.ToLower(string(exit.Stderr))
+		switch {
+		case strings.Contains(stderr, "no such image") || strings.Contains(stderr, "image not known"):
+			return false, nil
+		case !strings.Contains(stderr, "not a docker command") && !strings.Contains(stderr, "unknown command"):
+			return false, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exit.Stderr)))
+		}
+	}
+	debugf("Docker lacks image inspect, listing all images instead")
+	out, err := runner.Output(dockerCommand("images", "--no-trunc"))
+	if err != nil {
+		return false, err
+	}
+	return imageListed(out, image), nil
+}
+
+// Checks whether the output of docker images lists an image reference (untagged
+// ones meaning latest). Podman and some rootless setups qualify the repositories
+// with their registry (docker.io/, localhost/), so those prefixes are ignored.
+func imageListed(out []byte, image string) bool {
+	if strings.Contains(image, "@") {
+		return bytes.Contains(out, []byte(image)) // Digest references aren't listed by repository
+	}
+	repo, tag := image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		repo, tag = image[:i], image[i+1:]
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && unqualifiedRepo(fields[0]) == unqualifiedRepo(repo) && fields[1] == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Strips the default registry qualifiers from an image repository.
+func unqualifiedRepo(repo string) string {
+	for _, prefix := range []string{"docker.io/library/", "docker.io/", "localhost/"} {
+		if strings.HasPrefix(repo, prefix) {
+			return strings.TrimPrefix(repo, prefix)
+		}
+	}
+	return repo
+}
+
+// Pulls an image from the docker registry, bounded by the concurrent pull limit.
+func pullDockerImage(image string) error {
+	release, err := acquirePullSlot(*maxPulls)
+	if err != nil {
+		return fmt.Errorf("failed to acquire a pull slot: %v", err)
+	}
+	defer release()
+
+	fmt.Fprintf(infoOutput, "Pulling %s from docker registry...\n", image)
+	return run(dockerCommand("pull", image))
+}
+
+// Reads the module path declared in a go.mod file.
+func readModulePath(file string) (string, error) {
+	blob, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(blob), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], "\"`"), nil
+		}
+	}
+	return "", fmt.Errorf("%s declares no module path", file)
+}
+
+// Matcher for scp style version control remotes (e.g. git@github.com:user/repo).
+var scpRemote = regexp.MustCompile(`^([\w.-]+@)?[\w.-]+:[^/\\].*$`)
+
+// Checks whether a version control remote is well formed enough for the VCS in
+// the container to have any chance of fetching it.
+func validateRemote(remote string) error {
+	if !strings.Contains(remote, "://") {
+		if scpRemote.MatchString(remote) {
+			return nil
+		}
+		return errors.New("neither URL nor scp style remote")
+	}
+	uri, err := url.Parse(remote)
+	if err != nil {
+		return err
+	}
+	if !stringInSlice(uri.Scheme, []string{"http", "https", "git", "ssh"}) {
+		return fmt.Errorf("unsupported scheme %s", uri.Scheme)
+	}
+	if uri.Host == "" {
+		return errors.New("missing host")
+	}
+	if strings.Trim(uri.Path, "/") == "" {
+		return errors.New("missing repository path")
+	}
+	return nil
+}
+
+// Matcher for scp style repository URLs worth converting into import paths, stricter
+// than scpRemote: the host needs a dot (or a git@ user) so that things like foo:bar
+// or C:\path are not mistaken for URLs.
+var scpRepoURL = regexp.MustCompile(`^(?:git@([\w.-]+)|(?:[\w.-]+@)?([\w-]+(?:\.[\w-]+)+)):([^/\\].*)$`)
+
+// Converts a repository URL mistakenly given instead of an import path (e.g. pasted
+// https://github.com/user/repo, git://host/repo.git or git@host:user/repo.git) into
+// the import path it denotes, reporting whether the argument was indeed a URL.
+func importPathFromURL(arg string) (string, bool) {
+	var host, repo string
+	switch {
+	case strings.Contains(arg, "://"):
+		uri, err := url.Parse(arg)
+		if err != nil || uri.Hostname() == "" || !stringInSlice(uri.Scheme, []string{"http", "https", "git", "ssh", "git+ssh"}) {
+			return arg, false
+		}
+		host, repo = uri.Hostname(), uri.Path
+		if uri.Scheme == "http" || uri.Scheme == "https" {
+			repo = trimBrowserPath(repo)
+		}
+	case scpRepoURL.MatchString(arg):
+		parts := scpRepoURL.FindStringSubmatch(arg)
+		host, repo = parts[1]+parts[2], parts[3]
+	default:
+		return arg, false
+	}
+	repo = strings.TrimSuffix(strings.Trim(repo, "/"), ".git")
+	if repo == "" {
+		return arg, false
+	}
+	return host + "/" + repo, true
+}
+
+// Strips the file browser suffix off the path of a repository web page URL, such as
+// GitHub's /user/repo/tree/<branch> and /blob/<branch>/<file>, or GitLab's /-/ ones.
+// An owner named tree or blob is kept, only segments past user/repo are cut.
+func trimBrowserPath(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if segment == "-" || (i >= 2 && (segment == "tree" || segment == "blob")) {
+			return strings.Join(segments[:i], "/")
+		}
+	}
+	return path
+}
+
+// Checks whether an import path looks like a file system path instead (relative
+// or absolute, or an existing local folder).
+func isLocalPath(importPath string) bool {
+	if importPath == "." || importPath == ".." || importPath == "~" {
+		return true
+	}
+	for _, prefix := range []string{"./", "../", "/", "~/", ".\\", "..\\"} {
+		if strings.HasPrefix(importPath, prefix) {
+			return true
+		}
+	}
+	if filepath.IsAbs(importPath) {
+		return true
+	}
+	// Import paths start with a domain, so a matching local folder is suspicious
+	if info, err := os.Stat(importPath); err == nil && info.IsDir() && !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".") {
+		return true
+	}
+	return false
+}
+
+// Normalizes a repository relative path, rejecting anything that would resolve
+// outside of the repository itself.
+func cleanRelativePath(rel string) (string, error) {
+	if path.IsAbs(rel) {
+		return "", errors.New("path must be relative to the repository root")
+	}
+	clean := path.Clean(rel)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("path escapes the repository root")
+	}
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// Normalizes a sub-package path as users tend to pass it (e.g. ./cmd/foo, /cmd/foo
+// or cmd//foo/) into the plain repository relative form (cmd/foo).
+func cleanPackagePath(pkg string) (string, error) {
+	pkg = strings.Replace(pkg, "\\", "/", -1)
+	for strings.HasPrefix(pkg, "/") || strings.HasPrefix(pkg, "./") {
+		pkg = strings.TrimPrefix(strings.TrimPrefix(pkg, "/"), "./")
+	}
+	return cleanRelativePath(pkg)
+}
+
+// Major version suffix of a module path (e.g. /v2), which Go skips when naming binaries.
+var majorVersionRe = regexp.MustCompile(`^v([2-9]|[1-9][0-9]+)$`)
+
+// Returns the output prefix of the build: the explicit -out, or otherwise the last
+// element of the main package's path, skipping a trailing module major version the
+// same way go build does (github.com/user/tool/v2 builds tool, not v2).
+func outputName(config *ConfigFlags) string {
+	if config.Prefix != "" {
+		return config.Prefix
+	}
+	pkg := path.Join(config.Repository, config.ModuleRoot, config.Package)
+	name := path.Base(pkg)
+	if name != pkg && majorVersionRe.MatchString(name) {
+		name = path.Base(path.Dir(pkg))
+	}
+	return name
+}
+
+// Matcher for an explicit extension of the windows outputs (e.g. .dll).
+var windowsExtRe = regexp.MustCompile(`^\.[a-zA-Z0-9]+$`)
+
+// Returns the file extension of a target's outputs, derived from the operating
+// system and build mode, windows following the -windows-ext policy instead if set.
+func outputExtension(flags *BuildFlags, target *Target) string {
+	if target.OS == "windows" {
+		switch flags.WinExt {
+		case "auto", "":
+		case "none":
+			return ""
+		default:
+			return flags.WinExt
+		}
+	}
+	mode := flags.Mode.Value(target.Name)
+	if mode == "" {
+		mode = target.Mode
+	}
+	switch mode {
+	case "c-shared":
+		switch target.OS {
+		case "windows":
+			return ".dll"
+		case "darwin", "ios":
+			return ".dylib"
+		}
+		return ".so"
+	case "c-archive":
+		return ".a"
+	}
+	if target.OS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// Checks that an output prefix is a plain file name, so the container can't be
+// coerced into writing outside of the mounted output folder.
+func validateOutputPrefix(prefix string) error {
+	switch {
+	case prefix == "." || prefix == "..":
+		return errors.New("prefix must be a file name")
+	case path.IsAbs(prefix) || filepath.IsAbs(prefix):
+		return errors.New("absolute paths are not allowed")
+	case strings.Contains(prefix, ".."):
+		return errors.New("path traversal is not allowed")
+	case strings.ContainsAny(prefix, `/\`):
+		return errors.New("path separators are not allowed")
+	}
+	return nil
+}
+
+// Checks that the per target output names are all plain, distinct file names. A
+// name without a target is rejected, -out being the way to rename every output.
+func validateOutputNames(names *targetFlag) error {
+	if names.Default != "" {
+		return fmt.Errorf("%s has no target, expected <target>=<file> (use -out to rename all outputs)", names.Default)
+	}
+	owners := make(map[string]string)
+	for _, target := range knownTargets {
+		name, ok := names.Overrides[target.Name]
+		if !ok {
+			continue
+		}
+		if name == "" {
+			return fmt.Errorf("%s has an empty file name", target.Name)
+		}
+		if err := validateOutputPrefix(name); err != nil {
+			return fmt.Errorf("%s=%s: %v", target.Name, name, err)
+		}
+		if owner, ok := owners[name]; ok {
+			return fmt.Errorf("%s is used by both %s and %s", name, owner, target.Name)
+		}
+		owners[name] = target.Name
+	}
+	return nil
+}
+
+// Target is a single platform the cross compiler can build for.
+type Target struct {
+	Name   string // Canonical name of the target, also used as the output suffix
+	Alias  string // Legacy short name the target can be selected with
+	OS     string // Operating system of the target (GOOS)
+	Arch   string // Architecture of the target (GOARCH)
+	Extra  bool   // Needs toolchains beyond the stock image, excluded from all
+	Mode   string // Build mode of the target unless overridden (empty = executable)
+	PureGo bool   // Falls back to a pure Go build (CGO disabled) if the image lacks its C toolchain
+	Musl   bool   // Links against musl libc statically instead of glibc (e.g. for Alpine)
+}
+
+// Returns the GOOS/GOARCH pair of the target.
+func (t *Target) Platform() string {
+	return t.OS + "/" + t.Arch
+}
+
+// Platforms (GOOS/GOARCH) on which the Go race detector is supported.
+var racePlatforms = []string{
+	"linux/amd64", "linux/arm64", "linux/ppc64le",
+	"darwin/amd64", "darwin/arm64",
+	"windows/amd64",
+	"freebsd/amd64",
+}
+
+// Platforms (GOOS/GOARCH) on which the Go memory sanitizer integration is supported.
+var msanPlatforms = []string{"linux/amd64", "linux/arm64", "linux/loong64", "freebsd/amd64"}
+
+// Platforms (GOOS/GOARCH) on which the Go address sanitizer integration is supported.
+var asanPlatforms = []string{"linux/amd64", "linux/arm64", "linux/loong64", "linux/ppc64le", "linux/riscv64"}
+
+// Platforms (GOOS/GOARCH) on which position independent executables can be built
+// with the cross toolchains of the images.
+var piePlatforms = []string{
+	"linux/amd64", "linux/386", "linux/arm", "linux/arm64", "linux/riscv64", "linux/loong64",
+	"android/arm", "android/arm64", "android/amd64", "android/386",
+	"darwin/amd64", "darwin/arm64",
+	"windows/amd64", "windows/386",
+}
+
+// All the targets supported by the cross compiler, in build order.
+var knownTargets = []*Target{
+	{Name: "linux-amd64", Alias: "linux64", OS: "linux", Arch: "amd64"},
+	{Name: "linux-386", Alias: "linux386", OS: "linux", Arch: "386"},
+	{Name: "linux-arm", Alias: "linuxArm", OS: "linux", Arch: "arm"},
+	{Name: "windows-amd64", Alias: "windows64", OS: "windows", Arch: "amd64"},
+	{Name: "windows-386", Alias: "windows386", OS: "windows", Arch: "386"},
+	{Name: "darwin-amd64", Alias: "darwin64", OS: "darwin", Arch: "amd64"},
+	{Name: "darwin-386", Alias: "darwin386", OS: "darwin", Arch: "386"},
+	{Name: "android-arm", OS: "android", Arch: "arm", Extra: true},
+	{Name: "android-arm64", OS: "android", Arch: "arm64", Extra: true},
+	{Name: "android-amd64", OS: "android", Arch: "amd64", Extra: true},
+	{Name: "android-386", OS: "android", Arch: "386", Extra: true},
+	{Name: "ios-arm64", OS: "ios", Arch: "arm64", Extra: true, Mode: "c-archive"},
+	{Name: "ios-arm64-simulator", OS: "ios", Arch: "arm64", Extra: true, Mode: "c-archive"},
+	{Name: "ios-amd64-simulator", OS: "ios", Arch: "amd64", Extra: true, Mode: "c-archive"},
+	{Name: "linux-riscv64", OS: "linux", Arch: "riscv64", Extra: true, PureGo: true},
+	{Name: "linux-loong64", OS: "linux", Arch: "loong64", Extra: true, PureGo: true},
+	{Name: "freebsd-arm64", OS: "freebsd", Arch: "arm64", Extra: true, PureGo: true},
+	{Name: "linux-amd64-musl", OS: "linux", Arch: "amd64", Extra: true, Musl: true},
+	{Name: "linux-arm64-musl", OS: "linux", Arch: "arm64", Extra: true, Musl: true},
+}
+
+// targetFlag is a repeatable command line flag holding a value for all targets,
+// along with per target overrides. Values are comma separated lists, where a
+// <target>= prefix assigns an item and all following ones (up to the next such
+// prefix) to that specific target; items before any prefix form the default.
+// Flags with a custom separator take every value as a single item instead.
+type targetFlag struct {
+	Default   string            // Value applying to all targets without an override
+	Overrides map[string]string // Values applying to specific targets only
+	Sep       string            // Separator joining repeated values (empty = comma separated lists)
+}
+
+// Matcher for a per target prefix of a target flag item (e.g. linux-arm=).
+var targetPrefixRe = regexp.MustCompile(`^([a-zA-Z0-9]+-[a-zA-Z0-9-]+)=(.*)$`)
+
+// Defines a repeatable per target flag with the specified name and usage string.
+func targetVar(name string, usage string) *targetFlag {
+	f := &targetFlag{Overrides: make(map[string]string)}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *targetFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	items := []string{}
+	if f.Default != "" {
+		items = append(items, f.Default)
+	}
+	for _, target := range knownTargets {
+		if value, ok := f.Overrides[target.Name]; ok {
+			items = append(items, target.Name+"="+value)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (f *targetFlag) Set(value string) error {
+	items, sep := strings.Split(value, ","), ","
+	if f.Sep != "" {
+		items, sep = []string{value}, f.Sep
+	}
+	target := "" // Empty while collecting the default value
+	for _, item := range items {
+		if match := targetPrefixRe.FindStringSubmatch(item); match != nil {
+			known := findTarget(match[1])
+			if known == nil {
+				return fmt.Errorf("unknown target %s", match[1])
+			}
+			target, item = known.Name, match[2]
+		}
+		current := f.Default
+		if target != "" {
+			current = f.Overrides[target]
+		}
+		if current != "" {
+			current += sep
+		}
+		if target == "" {
+			f.Default = current + item
+		} else {
+			f.Overrides[target] = current + item
+		}
+	}
+	return nil
+}
+
+// Returns the value applying to a target: its override if set, or the default.
+func (f *targetFlag) Value(target string) string {
+	if value, ok := f.Overrides[target]; ok {
+		return value
+	}
+	return f.Default
+}
+
+// Returns the value applying to a target with its override appended to the
+// default instead of replacing it, for list flags that accumulate.
+func (f *targetFlag) Merged(target string) string {
+	value, ok := f.Overrides[target]
+	switch {
+	case !ok || value == "":
+		return f.Default
+	case f.Default == "":
+		return value
+	default:
+		return f.Default + "," + value
+	}
+}
+
+// Normalizes a comma and/or space separated list of build tags into a space
+// separated one without duplicates, understood by every Go release.
+func joinTags(tags string) string {
+	var unique []string
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !stringInSlice(tag, unique) {
+			unique = append(unique, tag)
+		}
+	}
+	return strings.Join(unique, " ")
+}
+
+// Returns the name of the environment variable a per target setting is passed
+// into the container with (e.g. CC_LINUX_ARM for CC and linux-arm).
+func targetEnvName(name string, target string) string {
+	return name + "_" + strings.ToUpper(strings.Replace(target, "-", "_", -1))
+}
+
+// Checks if a string is in the array
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Check which targets to compile for. Target names are matched case insensitively
+// and ignoring surrounding whitespace, with unknown ones returned separately. The
+// extra targets needing non-stock toolchains are only built if explicitly listed.
+func getTargets(targets string) ([]*Target, []string) {
+	var names []string
+	for _, name := range strings.Split(targets, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	all := selectsAll(targets)
+
+	var selected []*Target
+	for _, target := range knownTargets {
+		if (all && !target.Extra) || stringInSlice(target.Name, names) || stringInSlice(strings.ToLower(target.Alias), names) {
+			selected = append(selected, target)
+		}
+	}
+	var unknown []string
+	for _, name := range names {
+		if name != "all" && findTarget(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	return selected, unknown
+}
+
+// File in the working directory listing the project's default targets, one per line.
+const targetsFile = ".xgo.targets"
+
+// Reads the targets listed in a targets file, one per line, ignoring blank lines and
+// # comments. A file listing no targets at all is rejected.
+func readTargetsFile(path string) ([]string, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, line := range strings.Split(string(blob), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			targets = append(targets, line)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("no targets listed")
+	}
+	return targets, nil
+}
+
+// Checks whether a comma separated target list selects all the (non extra) targets.
+func selectsAll(targets string) bool {
+	for _, name := range strings.Split(targets, ",") {
+		if strings.ToLower(strings.TrimSpace(name)) == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// Looks up a known target by its canonical or legacy name (case insensitive).
+func findTarget(name string) *Target {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, target := range knownTargets {
+		if target.Name == name || (target.Alias != "" && strings.ToLower(target.Alias) == name) {
+			return target
+		}
+	}
+	return nil
+}
+
+// Checks whether any of the targets is of the given architecture.
+func hasArch(targets []*Target, arch string) bool {
+	for _, target := range targets {
+		if target.Arch == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks that the CGO setting of every target is a valid boolean.
+func checkCgoFlag(cgo *targetFlag) error {
+	values := []string{cgo.Default}
+	for _, value := range cgo.Overrides {
+		values = append(values, value)
+	}
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s (must be true or false)", value)
+		}
+	}
+	return nil
+}
+
+// Checks whether CGO is enabled for a target, defaulting to enabled.
+func cgoEnabled(flags *BuildFlags, target *Target) bool {
+	enabled, err := strconv.ParseBool(flags.Cgo.Value(target.Name))
+	return err != nil || enabled
+}
+
+// Returns the names of the targets the given runtime instrumentation (race detector
+// or sanitizer) can be enabled on, warning about the ones built without it.
+func instrumentedTargets(detector string, platforms []string, flags *BuildFlags, targets []*Target) []string {
+	var names []string
+	for _, target := range targets {
+		if !stringInSlice(target.Platform(), platforms) {
+			warnf("%s not supported on %s, building %s without.", detector, target.Platform(), target.Name)
+			continue
+		}
+		if !cgoEnabled(flags, target) {
+			warnf("%s needs CGO, building %s without.", detector, target.Name)
+			continue
+		}
+		if target.Musl {
+			warnf("%s needs glibc, building %s without.", detector, target.Name)
+			continue
+		}
+		names = append(names, target.Name)
+	}
+	return names
+}
+
+// Flags applying to a single repository, so they cannot be used when building
+// multiple import paths in one invocation.
+var singleRepoFlags = []string{"local", "source-archive", "watch", "remote", "branch", "out", "provenance", "manifest", "write-lock", "package", "only-changed-targets", "verify", "name"}
+
+// Regular expression matching a single GODEBUG setting (e.g. http2client=0).
+var godebugRe = regexp.MustCompile(`^[a-zA-Z0-9_]+=[a-zA-Z0-9_.-]+$`)
+
+// Regular expression matching a macOS release number (e.g. 10.6 or 10.6.8).
+var macOSVersionRe = regexp.MustCompile(`^[0-9]+\.[0-9]+(\.[0-9]+)?$`)
+
+// Checks whether any of the targets builds for the given operating system.
+func hasOS(targets []*Target, goos string) bool {
+	for _, target := range targets {
+		if target.OS == goos {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes the single artifact of a build onto stdout.
+func streamArtifact(folder string, artifacts []string) error {
+	if len(artifacts) != 1 {
+		return fmt.Errorf("build produced %d artifacts instead of one", len(artifacts))
+	}
+	file, err := os.Open(filepath.Join(folder, artifacts[0]))
 	if err != nil {
-		log.Fatalf("Failed to retrieve the working directory: %v.", err)
-	}
-
-	linux64, linux386, linuxArm, windows64, windows386, darwin64, darwin386 := getTargets(targets)
-
-	fmt.Printf("Cross compiling %s...\n", repo)
-	return run(exec.Command("docker", "run",
-		"-v", folder+":/build",
-		"-e", "REPO_REMOTE="+remote,
-		"-e", "REPO_BRANCH="+branch,
-		"-e", "PACK="+pack,
-		"-e", "LINUX64="+linux64,
-		"-e", "LINUX386="+linux386,
-		"-e", "LINUXARM="+linuxArm,
-		"-e", "WINDOWS64="+windows64,
-		"-e", "WINDOWS386="+windows386,
-		"-e", "DARWIN64="+darwin64,
-		"-e", "DARWIN386=%s"+darwin386,
-		"-e", "DEPS="+deps,
-		"-e", "OUT="+prefix,
-		"-e", fmt.Sprintf("FLAG_V=%v", verbose),
-		"-e", fmt.Sprintf("FLAG_RACE=%v", race),
-		dockerDist+*goVersion, repo))
-}
-
-// Executes a command synchronously, redirecting its output to stdout.
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(os.Stdout, file)
+	return err
+}
+
+// Assembles the linker flags of a build, appending the stripping ones to the
+// user's own if requested.
+func linkerFlags(flags *BuildFlags) string {
+	ldflags := flags.Ldflags
+	if flags.Strip {
+		ldflags = strings.TrimSpace(ldflags + " -s -w")
+	}
+	if flags.StripID {
+		ldflags = strings.TrimSpace(ldflags + " -buildid=")
+	}
+	if flags.Version != "" {
+		ldflags = strings.TrimSpace(ldflags + " -X " + flags.Version)
+	}
+	return ldflags
+}
+
+// Platforms (GOOS/GOARCH) whose C toolchains in the images can use each of the
+// selectable external linkers: the GNU binutils ship gold, which can't link riscv64
+// or loong64, the clang based toolchains lld.
+var linkerPlatforms = map[string][]string{
+	"gold": {"linux/amd64", "linux/386", "linux/arm", "linux/arm64"},
+	"lld": {
+		"linux/amd64", "linux/386", "linux/arm", "linux/arm64", "linux/riscv64", "linux/loong64",
+		"android/arm", "android/arm64", "android/amd64", "android/386",
+		"freebsd/arm64",
+	},
+}
+
+// Selectable external linkers the musl cross toolchains don't ship.
+var muslMissingLinkers = []string{"gold"}
+
+// Checks whether the requested external linker applies to a target, which needs
+// CGO enabled (pure Go binaries are linked internally) and a compatible toolchain.
+func linkerApplies(flags *BuildFlags, target *Target) bool {
+	if flags.Linker == "" || !cgoEnabled(flags, target) {
+		return false
+	}
+	if target.Musl && stringInSlice(flags.Linker, muslMissingLinkers) {
+		return false
+	}
+	return stringInSlice(target.Platform(), linkerPlatforms[flags.Linker])
+}
+
+// Assembles the linker flags of a target, merging the external linker flags it needs
+// (rpath, linker selection, static musl) into the single -extldflags Go honors.
+func targetLinkerFlags(flags *BuildFlags, target *Target) string {
+	var extldflags []string
+	if flags.Rpath != "" && target.OS != "windows" {
+		if target.OS == "darwin" || target.OS == "ios" {
+			// ld64 takes a single path per -rpath, split up the colon separated list
+			for _, rpath := range strings.Split(flags.Rpath, ":") {
+				if rpath != "" {
+					extldflags = append(extldflags, "-Wl,-rpath,"+strings.Replace(rpath, "$ORIGIN", "@loader_path", -1))
+				}
+			}
+		} else {
+			extldflags = append(extldflags, "-Wl,-rpath,"+flags.Rpath)
+		}
+	}
+	if linkerApplies(flags, target) {
+		extldflags = append(extldflags, "-fuse-ld="+flags.Linker)
+	}
+	if target.Musl && cgoEnabled(flags, target) {
+		extldflags = append(extldflags, "-static")
+	}
+	if len(extldflags) == 0 {
+		return linkerFlags(flags)
+	}
+	// Quote the external flags with a quote they lack, Go splits flags without escapes
+	ext, quote := strings.Join(extldflags, " "), "'"
+	if strings.Contains(ext, quote) {
+		quote = `"`
+	}
+	return strings.TrimSpace(linkerFlags(flags) + " -extldflags " + quote + ext + quote)
+}
+
+// Cross compiles a requested package into the specified output folder.
+func compile(image string, config *ConfigFlags, flags *BuildFlags, folder string) error {
+	targets, _ := getTargets(config.Targets)
+	fmt.Fprintf(infoOutput, "Building for %d target(s)...\n", len(targets))
+	if flags.GoAMD64 != "v1" && !hasArch(targets, "amd64") {
+		warnf("No amd64 target selected, ignoring -goamd64=%s.", flags.GoAMD64)
+	}
+	if flags.Go386 != "sse2" && !hasArch(targets, "386") {
+		warnf("No 386 target selected, ignoring -go386=%s.", flags.Go386)
+	}
+	if flags.MacOSMin != "" && !hasOS(targets, "darwin") {
+		warnf("No darwin target selected, ignoring -macosx-version-min=%s.", flags.MacOSMin)
+	}
+	names := make([]string, len(targets))
+	for i, target := range targets {
+		names[i] = target.Name
+	}
+	var race, msan, asan []string
+	if flags.Race {
+		race = instrumentedTargets("Race detector", racePlatforms, flags, targets)
+	}
+	if flags.MSan {
+		msan = instrumentedTargets("Memory sanitizer", msanPlatforms, flags, targets)
+		for _, name := range msan {
+			if cc := flags.CC.Value(name); !strings.Contains(cc, "clang") {
+				warnf("Memory sanitizer needs clang, %s will likely fail to link without -cc=%s=clang.", name, name)
+			}
+		}
+	}
+	if flags.ASan {
+		asan = instrumentedTargets("Address sanitizer", asanPlatforms, flags, targets)
+	}
+	args := []string{"run",
+		"-v", folder + ":" + *containerDir,
+		"-e", "BUILD_DIR=" + *containerDir,
+		"-e", "REPO_REMOTE=" + config.Remote,
+		"-e", "REPO_BRANCH=" + config.Branch,
+		"-e", "MODULE_ROOT=" + config.ModuleRoot,
+		"-e", "PACK=" + config.Package,
+		"-e", "TARGETS=" + strings.Join(names, ","),
+		"-e", "DEPS=" + config.Dependencies,
+		"-e", "DEPS_SHA256=" + config.DepChecksums,
+		"-e", "PRE_BUILD=" + config.PreBuild,
+		"-e", fmt.Sprintf("FLAG_GENERATE=%v", config.Generate),
+		"-e", "OUT=" + outputName(config),
+		"-e", fmt.Sprintf("KEEP_GOING=%v", config.KeepGoing),
+		"-e", fmt.Sprintf("FAIL_ON_WARNING=%v", config.FailOnWarn),
+		"-e", fmt.Sprintf("FLAG_COMPRESS=%v", config.Compress),
+		"-e", fmt.Sprintf("SKIP_EXISTING=%v", config.SkipExisting),
+		"-e", fmt.Sprintf("NO_CLOBBER=%v", *noClobber),
+		"-e", fmt.Sprintf("CLEAN_BUILD=%v", *cleanBuild),
+		"-e", fmt.Sprintf("FLAG_V=%v", flags.Verbose),
+		"-e", "RACE_TARGETS=" + strings.Join(race, ","),
+		"-e", "MSAN_TARGETS=" + strings.Join(msan, ","),
+		"-e", "ASAN_TARGETS=" + strings.Join(asan, ","),
+		"-e", "FLAG_TAGS=" + joinTags(flags.Tags.Default),
+		"-e", fmt.Sprintf("FLAG_TESTBIN=%v", flags.Test),
+		"-e", "FLAG_GOAMD64=" + flags.GoAMD64,
+		"-e", "FLAG_GO386=" + flags.Go386,
+		"-e", "FLAG_MACOSX_MIN=" + flags.MacOSMin,
+		"-e", "FLAG_GOEXPERIMENT=" + flags.GoExp,
+		"-e", "FLAG_GODEBUG=" + flags.Godebug,
+		"-e", "FLAG_ARGS=" + strings.Join(flags.Args, "\n"),
+		"-e", "FLAG_LDFLAGS=" + linkerFlags(flags),
+	}
+	// Let the C compilers use the release timestamp for __DATE__ and __TIME__
+	if *sourceDate != "" {
+		args = append(args, "-e", "SOURCE_DATE_EPOCH="+*sourceDate)
+	}
+	// Pass the previous input fingerprints of the targets if only the changed ones are built
+	if *onlyChanged != "" {
+		args = append(args, "-e", "ONLY_CHANGED=true", "-e", "IMAGE_ID="+imageDigest)
+		previous := manifestInputs(baseline)
+		for _, target := range targets {
+			if inputs, ok := previous[target.Name]; ok {
+				args = append(args, "-e", targetEnvName("PREV_INPUTS", target.Name)+"="+inputs)
+			}
+		}
+	}
+	// Mount the local sources over the import path if requested
+	if config.Local != "" {
+		args = append(args, "-v", config.Local+":/go/src/"+config.Repository)
+	}
+	// Pass all the per target settings in their own environment variables
+	for _, target := range targets {
+		if !cgoEnabled(flags, target) {
+			args = append(args, "-e", targetEnvName("FLAG_CGO", target.Name)+"=0")
+		}
+		ext := outputExtension(flags, target)
+		if ext != "" {
+			args = append(args, "-e", targetEnvName("FLAG_EXT", target.Name)+"="+ext)
+		}
+		if name, ok := outNames.Overrides[target.Name]; ok {
+			if target.OS == "windows" && ext != "" && !strings.HasSuffix(strings.ToLower(name), ext) {
+				warnf("Output name %s of %s lacks the %s extension, exact names are used as is.", name, target.Name, ext)
+			}
+			args = append(args, "-e", targetEnvName("FLAG_NAME", target.Name)+"="+name)
+		}
+		if cc := flags.CC.Value(target.Name); cc != "" {
+			args = append(args, "-e", targetEnvName("CC", target.Name)+"="+cc)
+		}
+		if cxxflags := flags.CXXFlags.Value(target.Name); cxxflags != "" {
+			args = append(args, "-e", targetEnvName("CGO_CXXFLAGS", target.Name)+"="+cxxflags)
+		}
+		if cppflags := flags.CPPFlags.Value(target.Name); cppflags != "" {
+			args = append(args, "-e", targetEnvName("CGO_CPPFLAGS", target.Name)+"="+cppflags)
+		}
+		if env := targetBuildEnv(buildEnvs, target.Name); len(env) > 0 {
+			args = append(args, "-e", targetEnvName("FLAG_ENV", target.Name)+"="+strings.Join(env, "\n"))
+		}
+		if flags.Rpath != "" && target.OS == "windows" {
+			warnf("Runtime library search paths are not supported on windows, building %s without.", target.Name)
+		}
+		if flags.Linker != "" && !linkerApplies(flags, target) {
+			warnf("Linker %s not applicable to %s (CGO disabled or incompatible toolchain), building it with the default one.", flags.Linker, target.Name)
+		}
+		if ldflags := targetLinkerFlags(flags, target); ldflags != linkerFlags(flags) {
+			args = append(args, "-e", targetEnvName("FLAG_LDFLAGS", target.Name)+"="+ldflags)
+		}
+		if _, ok := flags.Tags.Overrides[target.Name]; ok {
+			args = append(args, "-e", targetEnvName("FLAG_TAGS", target.Name)+"="+joinTags(flags.Tags.Merged(target.Name)))
+		}
+		mode := flags.Mode.Value(target.Name)
+		if mode == "" {
+			mode = target.Mode
+		}
+		if flags.PIE {
+			switch {
+			case mode == "pie":
+			case mode != "":
+				warnf("Target %s is built as %s, ignoring -pie for it.", target.Name, mode)
+			case target.Musl:
+				warnf("Position independent executables not supported for the static %s, building it without.", target.Name)
+			case !stringInSlice(target.Platform(), piePlatforms):
+				warnf("Position independent executables not supported on %s, building %s without.", target.Platform(), target.Name)
+			default:
+				mode = "pie"
+			}
+		}
+		if mode != "" {
+			args = append(args, "-e", targetEnvName("FLAG_BUILDMODE", target.Name)+"="+mode)
+		}
+	}
+	// Limit the container's memory if requested
+	if *dockerMemory != "" {
+		args = append(args, "--memory", *dockerMemory)
+	}
+	// Attach the container to a custom network if requested
+	if *dockerNetwork != "" {
+		option := "--network"
+		if !dockerVersionAtLeast(dockerNetworkOptionVersion) {
+			option = "--net"
+		}
+		args = append(args, option, *dockerNetwork)
+	}
+	// Resolve names via custom DNS servers and search domains if requested
+	for _, server := range splitList(*dockerDNS) {
+		args = append(args, "--dns", server)
+	}
+	for _, domain := range splitList(*dockerDNSSearch) {
+		args = append(args, "--dns-search", domain)
+	}
+	// Keep the scratch files in memory if requested
+	if *useTmpfs {
+		mount := containerTmpfs + ":rw,exec"
+		if *tmpfsSize != "" {
+			mount += ",size=" + *tmpfsSize
+		}
+		args = append(args, "--tmpfs", mount, "-e", "TMPDIR="+containerTmpfs)
+	}
+	// Hand the outputs of a rootful local daemon back to the invoking user, as the
+	// container writes them as root. Rootless daemons already map their root to the
+	// user, so chowning there would hand them to one of the user's subordinate ids.
+	if !*rootless && runtime.GOOS == "linux" && remoteDockerHost() == "" {
+		args = append(args, "-e", fmt.Sprintf("OWNER=%d:%d", os.Getuid(), os.Getgid()))
+	}
+	// Inject any custom entrypoint, and replace its arguments if requested
+	if *entrypoint != "" {
+		args = append(args, "--entrypoint", *entrypoint)
+	}
+	args = append(args, image)
+	if *entryArgs != "" {
+		args = append(args, strings.Fields(*entryArgs)...)
+	} else {
+		args = append(args, config.Repository)
+	}
+	fmt.Fprintf(infoOutput, "Cross compiling %s...\n", config.Repository)
+	cmd := dockerCommand(args...)
+	if *githubOutput {
+		stdout, stderr := newGithubWriter(logOutput, config), newGithubWriter(os.Stderr, config)
+		defer stdout.Close()
+		defer stderr.Close()
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+	}
+	var buffer *logBuffer
+	if *summaryOnly {
+		buffer = new(logBuffer)
+		cmd.Stdout, cmd.Stderr = buffer, buffer
+	}
+	if progress != nil {
+		progress.begin(config.Repository, targets)
+
+		stdout := cmd.Stdout
+		if stdout == nil {
+			stdout = logOutput
+		}
+		cmd.Stdout = io.MultiWriter(stdout, progress)
+	}
+	if err := run(cmd); err != nil {
+		if buffer != nil {
+			buffer.replayFailures(logOutput)
+		}
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == oomExitCode {
+			limit := *dockerMemory
+			if limit == "" {
+				limit = "the docker default"
+			}
+			return buildError(fmt.Errorf("container killed with exit code %d, most probably ran out of memory: increase the limit via -memory (currently %s)", oomExitCode, limit))
+		}
+		if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() == noMainExitCode {
+			path := strings.TrimSuffix(config.Repository+"/"+config.Package, "/")
+			return &Error{Kind: ErrUsage, Err: fmt.Errorf("%s is not a main package, nothing to output: select a command via -pkg (or build test binaries via -testbin)", path)}
+		}
+		// Docker itself reports its own failures with 125-127, the rest is the build's
+		if exit, ok := err.(*exec.ExitError); ok && (exit.ExitCode() < 125 || exit.ExitCode() > 127) {
+			return buildError(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Path of the in-memory scratch space inside the container, if requested via -tmpfs.
+const containerTmpfs = "/build-tmp"
+
+// Exit code of the build script if the package to build is not a command (EX_USAGE).
+const noMainExitCode = 64
+
+// Exit code of a container killed by the kernel (SIGKILL), the symptom of it
+// exceeding its memory limit.
+const oomExitCode = 137
+
+// Runner assembles and executes the external commands of xgo (docker, git, hooks,
+// packagers and uploaders), so that all of them can be substituted (e.g. by a fake
+// recording the invocations and returning canned outputs) without touching the host.
+type Runner interface {
+	Command(name string, args ...string) *exec.Cmd // Assembles a command to execute
+	LookPath(file string) (string, error)          // Locates an executable on the host
+	Run(cmd *exec.Cmd) error                       // Executes a command, waiting for it to finish
+	Output(cmd *exec.Cmd) ([]byte, error)          // Executes a command, returning its standard output
+}
+
+// execRunner is the default runner, executing the commands on the host.
+type execRunner struct{}
+
+func (execRunner) Command(name string, args ...string) *exec.Cmd { return exec.Command(name, args...) }
+func (execRunner) LookPath(file string) (string, error)          { return exec.LookPath(file) }
+func (execRunner) Run(cmd *exec.Cmd) error                       { return cmd.Run() }
+func (execRunner) Output(cmd *exec.Cmd) ([]byte, error)          { return cmd.Output() }
+
+// Runner to execute all external commands through.
+var runner Runner = execRunner{}
+
+// Executes a command synchronously, redirecting its output into the logs unless
+// explicitly redirected already.
 func run(cmd *exec.Cmd) error {
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if cmd.Stdout == nil {
+		cmd.Stdout = logOutput
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if logSink != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, logSink)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, logSink)
+	}
 
-	return cmd.Run()
+	return runner.Run(cmd)
 }
@@ -0,0 +1,810 @@
+// Go CGO cross compiler
+// Copyright (c) 2014 Péter Szilágyi. All rights reserved.
+//
+// Released under the MIT license.
+
+// Tests of the command line wrapper, running docker through a recording fake.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fakeRunner is a Runner recording every command instead of executing it, and
+// answering them with canned outputs and exit codes.
+type fakeRunner struct {
+	calls   [][]string                                // Arguments of every executed command, in order
+	respond func(args []string) (string, int, string) // Stdout, exit code and stderr of a command (nil = success)
+}
+
+func (f *fakeRunner) Command(name string, args ...string) *exec.Cmd {
+	return &exec.Cmd{Path: name, Args: append([]string{name}, args...)}
+}
+
+func (f *fakeRunner) LookPath(file string) (string, error) { return file, nil }
+
+func (f *fakeRunner) Run(cmd *exec.Cmd) error {
+	out, err := f.Output(cmd)
+	if cmd.Stdout != nil {
+		cmd.Stdout.Write(out)
+	}
+	return err
+}
+
+func (f *fakeRunner) Output(cmd *exec.Cmd) ([]byte, error) {
+	f.calls = append(f.calls, cmd.Args)
+	if f.respond == nil {
+		return nil, nil
+	}
+	stdout, code, stderr := f.respond(cmd.Args)
+	if code == 0 {
+		return []byte(stdout), nil
+	}
+	return []byte(stdout), exitError(code, stderr)
+}
+
+// Returns the recorded commands whose arguments start with the given ones.
+func (f *fakeRunner) matching(prefix ...string) [][]string {
+	var calls [][]string
+	for _, call := range f.calls {
+		if len(call) >= len(prefix) && strings.Join(call[:len(prefix)], " ") == strings.Join(prefix, " ") {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Creates a genuine process exit error with the given code and stderr, as returned
+// by a failed command.
+func exitError(code int, stderr string) error {
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	exit, ok := err.(*exec.ExitError)
+	if !ok {
+		panic(fmt.Sprintf("failed to fake exit code %d: %v", code, err))
+	}
+	exit.Stderr = []byte(stderr)
+	return exit
+}
+
+// Replaces the runner and the docker version for the duration of a test, silencing
+// the informational output.
+func useFakeRunner(t *testing.T, fake *fakeRunner, version string) {
+	oldRunner, oldVersion, oldInfo, oldLog, oldPulls := runner, dockerVersion, infoOutput, logOutput, *maxPulls
+	t.Cleanup(func() {
+		runner, dockerVersion, infoOutput, logOutput, *maxPulls = oldRunner, oldVersion, oldInfo, oldLog, oldPulls
+	})
+	runner, dockerVersion, infoOutput, logOutput, *maxPulls = fake, version, io.Discard, io.Discard, 0
+}
+
+// Creates a set of build flags with every setting at its default.
+func defaultBuildFlags() *BuildFlags {
+	empty := func() *targetFlag { return &targetFlag{Overrides: make(map[string]string)} }
+	return &BuildFlags{
+		GoAMD64:  "v1",
+		Go386:    "sse2",
+		WinExt:   "auto",
+		Tags:     empty(),
+		Mode:     empty(),
+		Cgo:      empty(),
+		CC:       empty(),
+		CXXFlags: empty(),
+		CPPFlags: empty(),
+	}
+}
+
+// Tests that local images are found via docker image inspect, falling back to the
+// image listing on clients lacking it.
+func TestCheckDockerImage(t *testing.T) {
+	tests := []struct {
+		version string // Docker client version the check runs against
+		inspect int    // Exit code of docker image inspect
+		stderr  string // Error output of docker image inspect
+		listing string // Output of docker images
+		found   bool   // Whether the image should be found
+		fails   bool   // Whether the check should fail
+		listed  bool   // Whether the images should have been listed
+	}{
+		{version: "24.0.7", found: true},
+		{version: "24.0.7", inspect: 1, stderr: "Error: No such image: karalabe/xgo-latest"},
+		{version: "24.0.7", inspect: 125, stderr: "Error: karalabe/xgo-latest: image not known"},
+		{version: "24.0.7", inspect: 1, stderr: "Cannot connect to the Docker daemon", fails: true},
+		{version: "", inspect: 1, stderr: "docker: 'image' is not a docker command.", listing: "karalabe/xgo-latest latest abc", found: true, listed: true},
+		{version: "", inspect: 1, stderr: "unknown command \"image\"", listing: "karalabe/xgo-1.21 latest abc", listed: true},
+		{version: "1.12.6", listing: "docker.io/karalabe/xgo-latest latest abc", found: true, listed: true},
+	}
+	for i, tt := range tests {
+		fake := &fakeRunner{respond: func(args []string) (string, int, string) {
+			if args[1] == "images" {
+				return tt.listing, 0, ""
+			}
+			return "sha256:abc", tt.inspect, tt.stderr
+		}}
+		useFakeRunner(t, fake, tt.version)
+
+		found, err := checkDockerImage("karalabe/xgo-latest")
+		if (err != nil) != tt.fails {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fails)
+		}
+		if found != tt.found {
+			t.Errorf("test %d: found mismatch: have %v, want %v", i, found, tt.found)
+		}
+		if listed := len(fake.matching("docker", "images")) > 0; listed != tt.listed {
+			t.Errorf("test %d: listing mismatch: have %v, want %v", i, listed, tt.listed)
+		}
+		if tt.version == "1.12.6" && len(fake.matching("docker", "image", "inspect")) > 0 {
+			t.Errorf("test %d: docker %s lacks image inspect, but it was invoked", i, tt.version)
+		}
+	}
+}
+
+// Tests that images are pulled according to the pull policy.
+func TestEnsureDockerImage(t *testing.T) {
+	tests := []struct {
+		policy string // Pull policy to apply
+		local  bool   // Whether the image is available locally
+		pulled bool   // Whether the image should have been pulled
+		fails  bool   // Whether preparing the image should fail
+	}{
+		{policy: "missing", local: true},
+		{policy: "missing", pulled: true},
+		{policy: "always", local: true, pulled: true},
+		{policy: "never", local: true},
+		{policy: "never", fails: true},
+	}
+	for i, tt := range tests {
+		fake := &fakeRunner{respond: func(args []string) (string, int, string) {
+			if args[1] == "image" && !tt.local {
+				return "", 1, "Error: No such image: karalabe/xgo-latest"
+			}
+			return "", 0, ""
+		}}
+		useFakeRunner(t, fake, "24.0.7")
+
+		err := ensureDockerImage("karalabe/xgo-latest", tt.policy)
+		if (err != nil) != tt.fails {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fails)
+		}
+		pulls := fake.matching("docker", "pull", "karalabe/xgo-latest")
+		if pulled := len(pulls) > 0; pulled != tt.pulled {
+			t.Errorf("test %d: pull mismatch: have %v, want %v", i, pulled, tt.pulled)
+		}
+	}
+}
+
+// Tests that the build settings are passed to the container as documented by the
+// build script, and that the global docker flags precede the subcommand.
+func TestCompileDockerRun(t *testing.T) {
+	fake := new(fakeRunner)
+	useFakeRunner(t, fake, "24.0.7")
+
+	oldHost := *dockerHost
+	defer func() { *dockerHost = oldHost }()
+	*dockerHost = "tcp://build:2375"
+
+	config := &ConfigFlags{
+		Repository: "github.com/project-iris/iris",
+		Package:    "cmd/iris",
+		Targets:    "linux-amd64,windows-amd64",
+		KeepGoing:  true,
+	}
+	flags := defaultBuildFlags()
+	flags.Tags.Set("netgo,linux-amd64=osusergo")
+	flags.Cgo.Set("windows-amd64=false")
+	flags.Args = []string{"-trimpath"}
+
+	if err := compile("karalabe/xgo-latest", config, flags, "/tmp/out"); err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	runs := fake.matching("docker", "-H", "tcp://build:2375", "run")
+	if len(runs) != 1 {
+		t.Fatalf("docker run invocations mismatch: have %d, want 1 (calls: %v)", len(runs), fake.calls)
+	}
+	args := runs[0]
+	if have := args[len(args)-2:]; have[0] != "karalabe/xgo-latest" || have[1] != "github.com/project-iris/iris" {
+		t.Errorf("trailing arguments mismatch: have %v, want image and import path", have)
+	}
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"-v /tmp/out:/build",
+		"-e BUILD_DIR=/build",
+		"-e PACK=cmd/iris",
+		"-e TARGETS=linux-amd64,windows-amd64",
+		"-e OUT=iris",
+		"-e KEEP_GOING=true",
+		"-e FLAG_TAGS=netgo",
+		"-e FLAG_TAGS_LINUX_AMD64=netgo osusergo",
+		"-e FLAG_CGO_WINDOWS_AMD64=0",
+		"-e FLAG_EXT_WINDOWS_AMD64=.exe",
+		"-e FLAG_ARGS=-trimpath",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("docker run lacks %q: %v", want, args)
+		}
+	}
+	for _, unwanted := range []string{"FLAG_CGO_LINUX_AMD64", "FLAG_EXT_LINUX_AMD64", "FLAG_TAGS_WINDOWS_AMD64"} {
+		if strings.Contains(joined, unwanted) {
+			t.Errorf("docker run has unexpected %s: %v", unwanted, args)
+		}
+	}
+}
+
+// Tests that the exit codes of the build container are mapped to the failure
+// classes, and those to the exit codes of xgo.
+func TestCompileExitCodes(t *testing.T) {
+	tests := []struct {
+		code int   // Exit code of the build container
+		kind error // Expected failure class (nil = success)
+		exit int   // Expected exit code of xgo
+	}{
+		{code: 0},
+		{code: 1, kind: ErrBuild, exit: 3},
+		{code: noMainExitCode, kind: ErrUsage, exit: 2},
+		{code: oomExitCode, kind: ErrBuild, exit: 3},
+		{code: 125, kind: ErrSystem, exit: 1},
+		{code: 127, kind: ErrSystem, exit: 1},
+	}
+	for _, tt := range tests {
+		fake := &fakeRunner{respond: func(args []string) (string, int, string) { return "", tt.code, "" }}
+		useFakeRunner(t, fake, "24.0.7")
+
+		config := &ConfigFlags{Repository: "github.com/project-iris/iris", Targets: "linux-amd64"}
+		err := compile("karalabe/xgo-latest", config, defaultBuildFlags(), "/tmp/out")
+		if tt.kind == nil {
+			if err != nil {
+				t.Errorf("exit %d: unexpected failure: %v", tt.code, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("exit %d: failure missing", tt.code)
+			continue
+		}
+		if kind := failureKind(err); kind != tt.kind {
+			t.Errorf("exit %d: failure class mismatch: have %v, want %v", tt.code, kind, tt.kind)
+		}
+		if exit := exitCode(err); exit != tt.exit {
+			t.Errorf("exit %d: exit code mismatch: have %d, want %d", tt.code, exit, tt.exit)
+		}
+		if tt.code == oomExitCode && !strings.Contains(err.Error(), "-memory") {
+			t.Errorf("exit %d: out of memory failure doesn't suggest -memory: %v", tt.code, err)
+		}
+	}
+}
+
+// Tests that target lists select the documented targets, in build order and
+// without duplicates, reporting the unknown names separately.
+func TestGetTargets(t *testing.T) {
+	stock := []string{"linux-amd64", "linux-386", "linux-arm", "windows-amd64", "windows-386", "darwin-amd64", "darwin-386"}
+
+	tests := []struct {
+		targets  string   // Comma separated target list to select with
+		selected []string // Names of the targets to be selected
+		unknown  []string // Names to be reported as unknown
+	}{
+		// Every target by its canonical name
+		{targets: "linux-amd64", selected: []string{"linux-amd64"}},
+		{targets: "linux-386", selected: []string{"linux-386"}},
+		{targets: "linux-arm", selected: []string{"linux-arm"}},
+		{targets: "windows-amd64", selected: []string{"windows-amd64"}},
+		{targets: "windows-386", selected: []string{"windows-386"}},
+		{targets: "darwin-amd64", selected: []string{"darwin-amd64"}},
+		{targets: "darwin-386", selected: []string{"darwin-386"}},
+		{targets: "android-arm", selected: []string{"android-arm"}},
+		{targets: "android-arm64", selected: []string{"android-arm64"}},
+		{targets: "android-amd64", selected: []string{"android-amd64"}},
+		{targets: "android-386", selected: []string{"android-386"}},
+		{targets: "ios-arm64", selected: []string{"ios-arm64"}},
+		{targets: "ios-arm64-simulator", selected: []string{"ios-arm64-simulator"}},
+		{targets: "ios-amd64-simulator", selected: []string{"ios-amd64-simulator"}},
+		{targets: "linux-riscv64", selected: []string{"linux-riscv64"}},
+		{targets: "linux-loong64", selected: []string{"linux-loong64"}},
+		{targets: "freebsd-arm64", selected: []string{"freebsd-arm64"}},
+		{targets: "linux-amd64-musl", selected: []string{"linux-amd64-musl"}},
+		{targets: "linux-arm64-musl", selected: []string{"linux-arm64-musl"}},
+
+		// Every target by its legacy alias
+		{targets: "linux64", selected: []string{"linux-amd64"}},
+		{targets: "linux386", selected: []string{"linux-386"}},
+		{targets: "linuxArm", selected: []string{"linux-arm"}},
+		{targets: "windows64", selected: []string{"windows-amd64"}},
+		{targets: "windows386", selected: []string{"windows-386"}},
+		{targets: "darwin64", selected: []string{"darwin-amd64"}},
+		{targets: "darwin386", selected: []string{"darwin-386"}},
+
+		// Combinations, built in order and only once
+		{targets: "windows-amd64,linux-arm", selected: []string{"linux-arm", "windows-amd64"}},
+		{targets: "darwin64,android-arm64,linux386", selected: []string{"linux-386", "darwin-amd64", "android-arm64"}},
+		{targets: "linux-amd64,linux64,linux-amd64", selected: []string{"linux-amd64"}},
+		{targets: "linux-amd64,linux-amd64-musl", selected: []string{"linux-amd64", "linux-amd64-musl"}},
+
+		// All the stock targets, the extra ones only if listed explicitly
+		{targets: "all", selected: stock},
+		{targets: "all,linux-amd64", selected: stock},
+		{targets: "ios-arm64,all", selected: append(append([]string{}, stock...), "ios-arm64")},
+		{targets: "all,linux-mips", selected: stock, unknown: []string{"linux-mips"}},
+
+		// Unknown names, reported without failing the known ones
+		{targets: "linux-mips", unknown: []string{"linux-mips"}},
+		{targets: "linux-amd64,plan9-386", selected: []string{"linux-amd64"}, unknown: []string{"plan9-386"}},
+		{targets: "linux-amd64;linux-arm", unknown: []string{"linux-amd64;linux-arm"}},
+		{targets: "linux/amd64", unknown: []string{"linux/amd64"}},
+		{targets: "*", unknown: []string{"*"}},
+
+		// Empty lists and items
+		{targets: ""},
+		{targets: ","},
+		{targets: " , \t"},
+		{targets: "linux-arm,,windows-386,", selected: []string{"linux-arm", "windows-386"}},
+
+		// Whitespace and case variants
+		{targets: " linux-amd64 ", selected: []string{"linux-amd64"}},
+		{targets: "\tdarwin-386\n", selected: []string{"darwin-386"}},
+		{targets: "Linux-AMD64", selected: []string{"linux-amd64"}},
+		{targets: "LINUX64", selected: []string{"linux-amd64"}},
+		{targets: "linuxarm", selected: []string{"linux-arm"}},
+		{targets: " Windows-386 ,  darwin64", selected: []string{"windows-386", "darwin-amd64"}},
+		{targets: " ALL ", selected: stock},
+		{targets: "All,IOS-ARM64-Simulator", selected: append(append([]string{}, stock...), "ios-arm64-simulator")},
+		{targets: " Linux-Mips ", unknown: []string{"linux-mips"}},
+	}
+	// Ensure the table covers every target and alias, including future ones
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		covered[tt.targets] = true
+	}
+	for _, target := range knownTargets {
+		if !covered[target.Name] {
+			t.Errorf("target %s not covered by the tests", target.Name)
+		}
+		if target.Alias != "" && !covered[target.Alias] {
+			t.Errorf("alias %s of target %s not covered by the tests", target.Alias, target.Name)
+		}
+	}
+	for _, tt := range tests {
+		selected, unknown := getTargets(tt.targets)
+
+		var names []string
+		for _, target := range selected {
+			names = append(names, target.Name)
+			if known := findTarget(target.Name); known != target {
+				t.Errorf("targets %q: selected %s is not the known target entry", tt.targets, target.Name)
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(tt.selected, ",") {
+			t.Errorf("targets %q: selection mismatch: have %v, want %v", tt.targets, names, tt.selected)
+		}
+		if strings.Join(unknown, ",") != strings.Join(tt.unknown, ",") {
+			t.Errorf("targets %q: unknown mismatch: have %v, want %v", tt.targets, unknown, tt.unknown)
+		}
+	}
+}
+
+// Tests that the go build arguments are split off at the -- terminator, wherever
+// it's placed, and that misplaced flags and import paths are rejected.
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		raw        []string // Command line arguments after the program name
+		args       []string // Arguments left over by the flag package
+		positional []string // Expected positional arguments
+		extra      []string // Expected go build arguments
+		fails      bool     // Whether the split should be rejected
+	}{
+		// Terminator after the import path
+		{
+			raw:        []string{"-targets", "linux-amd64", "github.com/x/y", "--", "-trimpath"},
+			args:       []string{"github.com/x/y", "--", "-trimpath"},
+			positional: []string{"github.com/x/y"},
+			extra:      []string{"-trimpath"},
+		},
+		{
+			raw:        []string{"github.com/x/y", "github.com/x/z", "--", "-gcflags", "all=-N"},
+			args:       []string{"github.com/x/y", "github.com/x/z", "--", "-gcflags", "all=-N"},
+			positional: []string{"github.com/x/y", "github.com/x/z"},
+			extra:      []string{"-gcflags", "all=-N"},
+		},
+		// Terminator swallowed by the flag package, before any positional
+		{
+			raw:   []string{"-targets", "linux-amd64", "--", "-trimpath"},
+			args:  []string{"-trimpath"},
+			extra: []string{"-trimpath"},
+		},
+		{
+			raw:   []string{"-v", "--", "-a", "-gcflags", "all=-N"},
+			args:  []string{"-a", "-gcflags", "all=-N"},
+			extra: []string{"-a", "-gcflags", "all=-N"},
+		},
+		{
+			raw:  []string{"-targets=linux-amd64", "--"},
+			args: []string{},
+		},
+		// Flags, then the terminator, then go build flags and the import path
+		{
+			raw:   []string{"-targets", "linux-amd64", "--", "-trimpath", "github.com/x/y"},
+			args:  []string{"-trimpath", "github.com/x/y"},
+			fails: true,
+		},
+		{
+			raw:   []string{"-strip-build-id", "-verify", "--", "-trimpath", "github.com/project-iris/iris"},
+			args:  []string{"-trimpath", "github.com/project-iris/iris"},
+			fails: true,
+		},
+		// A terminator consumed as the value of a flag is no terminator
+		{
+			raw:        []string{"-tags", "--", "github.com/x/y"},
+			args:       []string{"github.com/x/y"},
+			positional: []string{"github.com/x/y"},
+		},
+		// Go build flags lacking the terminator
+		{
+			raw:   []string{"github.com/x/y", "-trimpath"},
+			args:  []string{"github.com/x/y", "-trimpath"},
+			fails: true,
+		},
+		// No arguments at all
+		{raw: []string{"-targets", "linux-amd64"}, args: []string{}},
+	}
+	for i, tt := range tests {
+		positional, extra, err := splitArgs(tt.raw, tt.args)
+		if (err != nil) != tt.fails {
+			t.Errorf("test %d: failure mismatch: have %v, want failure %v", i, err, tt.fails)
+			continue
+		}
+		if strings.Join(positional, " ") != strings.Join(tt.positional, " ") {
+			t.Errorf("test %d: positional mismatch: have %q, want %q", i, positional, tt.positional)
+		}
+		if strings.Join(extra, " ") != strings.Join(tt.extra, " ") {
+			t.Errorf("test %d: go build arguments mismatch: have %q, want %q", i, extra, tt.extra)
+		}
+	}
+}
+
+// Tests that sub-package paths are normalized into the plain repository relative
+// form, rejecting the ones escaping the repository.
+func TestCleanPackagePath(t *testing.T) {
+	tests := []struct {
+		pkg   string // Sub-package path as given by the user
+		clean string // Expected normalized path
+		fails bool   // Whether the path should be rejected
+	}{
+		{pkg: "pkg", clean: "pkg"},
+		{pkg: "/pkg", clean: "pkg"},
+		{pkg: "//pkg", clean: "pkg"},
+		{pkg: "./pkg", clean: "pkg"},
+		{pkg: "././pkg", clean: "pkg"},
+		{pkg: "pkg/", clean: "pkg"},
+		{pkg: "./cmd/foo/", clean: "cmd/foo"},
+		{pkg: "cmd//foo", clean: "cmd/foo"},
+		{pkg: `.\cmd\foo`, clean: "cmd/foo"},
+		{pkg: "cmd/../foo", clean: "foo"},
+		{pkg: ".", clean: ""},
+		{pkg: "./", clean: ""},
+		{pkg: "/", clean: ""},
+		{pkg: "..", fails: true},
+		{pkg: "../pkg", fails: true},
+		{pkg: "./../pkg", fails: true},
+		{pkg: "cmd/../..", fails: true},
+		{pkg: `..\pkg`, fails: true},
+	}
+	for _, tt := range tests {
+		clean, err := cleanPackagePath(tt.pkg)
+		if (err != nil) != tt.fails {
+			t.Errorf("package %q: failure mismatch: have %v, want failure %v", tt.pkg, err, tt.fails)
+			continue
+		}
+		if clean != tt.clean {
+			t.Errorf("package %q: path mismatch: have %q, want %q", tt.pkg, clean, tt.clean)
+		}
+	}
+}
+
+// Tests that outputs are named after the main package the same way go build names
+// the binaries, for both module and GOPATH style import paths.
+func TestOutputName(t *testing.T) {
+	tests := []struct {
+		config *ConfigFlags // Build configuration to name the outputs of
+		name   string       // Expected output prefix
+	}{
+		// Module paths, skipping the major version suffix
+		{&ConfigFlags{Repository: "github.com/user/tool"}, "tool"},
+		{&ConfigFlags{Repository: "github.com/user/tool/v2"}, "tool"},
+		{&ConfigFlags{Repository: "github.com/user/tool/v10"}, "tool"},
+		{&ConfigFlags{Repository: "github.com/user/tool/v1"}, "v1"},
+		{&ConfigFlags{Repository: "github.com/user/tool/v0"}, "v0"},
+		{&ConfigFlags{Repository: "github.com/user/tool/v02"}, "v02"},
+		{&ConfigFlags{Repository: "github.com/user/tool/v2beta"}, "v2beta"},
+		{&ConfigFlags{Repository: "gopkg.in/yaml.v3"}, "yaml.v3"},
+		{&ConfigFlags{Repository: "v2"}, "v2"},
+
+		// Commands within the repository
+		{&ConfigFlags{Repository: "github.com/user/repo", Package: "cmd/tool"}, "tool"},
+		{&ConfigFlags{Repository: "github.com/user/repo/v2", Package: "cmd/tool"}, "tool"},
+		{&ConfigFlags{Repository: "github.com/user/repo/v3", Package: "cmd/tool/v3"}, "tool"},
+		{&ConfigFlags{Repository: "github.com/user/repo", Package: "cmd/v2"}, "cmd"},
+		{&ConfigFlags{Repository: "github.com/user/repo", Package: "cmd"}, "cmd"},
+		{&ConfigFlags{Repository: "github.com/user/mono", ModuleRoot: "tools", Package: "cmd/lint"}, "lint"},
+		{&ConfigFlags{Repository: "github.com/user/mono", ModuleRoot: "tools/v2"}, "tools"},
+
+		// GOPATH style import paths
+		{&ConfigFlags{Repository: "github.com/project-iris/iris"}, "iris"},
+		{&ConfigFlags{Repository: "code.google.com/p/go.net/ipv4"}, "ipv4"},
+		{&ConfigFlags{Repository: "bitbucket.org/user/repo", Package: "cmd/server"}, "server"},
+		{&ConfigFlags{Repository: "tool"}, "tool"},
+
+		// Explicit prefixes override everything
+		{&ConfigFlags{Repository: "github.com/user/tool/v2", Prefix: "mytool"}, "mytool"},
+		{&ConfigFlags{Repository: "github.com/user/repo", Package: "cmd/tool", Prefix: "release"}, "release"},
+	}
+	for _, tt := range tests {
+		if name := outputName(tt.config); name != tt.name {
+			t.Errorf("config %+v: name mismatch: have %s, want %s", *tt.config, name, tt.name)
+		}
+	}
+}
+
+// Tests that the output extensions follow the operating system and build mode of
+// the targets, windows ones obeying the -windows-ext policy.
+func TestOutputExtension(t *testing.T) {
+	tests := []struct {
+		target string // Name of the target to build
+		policy string // Windows extension policy
+		mode   string // Build mode override of the target
+		ext    string // Expected extension of the outputs
+	}{
+		{target: "windows-amd64", policy: "auto", ext: ".exe"},
+		{target: "windows-386", policy: "auto", ext: ".exe"},
+		{target: "windows-amd64", policy: "", ext: ".exe"},
+		{target: "windows-amd64", policy: "auto", mode: "c-shared", ext: ".dll"},
+		{target: "windows-amd64", policy: "auto", mode: "c-archive", ext: ".a"},
+		{target: "windows-amd64", policy: "none", ext: ""},
+		{target: "windows-386", policy: "none", mode: "c-shared", ext: ""},
+		{target: "windows-amd64", policy: ".scr", ext: ".scr"},
+		{target: "windows-amd64", policy: ".dll", mode: "c-shared", ext: ".dll"},
+		{target: "windows-386", policy: ".com", mode: "c-archive", ext: ".com"},
+
+		// Non windows targets ignore the policy
+		{target: "linux-amd64", policy: "auto", ext: ""},
+		{target: "linux-amd64", policy: ".exe", ext: ""},
+		{target: "linux-arm", policy: "none", mode: "c-shared", ext: ".so"},
+		{target: "darwin-amd64", policy: ".exe", mode: "c-shared", ext: ".dylib"},
+		{target: "android-arm64", policy: "auto", mode: "c-archive", ext: ".a"},
+		{target: "ios-arm64", policy: "auto", ext: ".a"},
+	}
+	for _, tt := range tests {
+		flags := defaultBuildFlags()
+		flags.WinExt = tt.policy
+		if tt.mode != "" {
+			flags.Mode.Set(tt.target + "=" + tt.mode)
+		}
+		if ext := outputExtension(flags, findTarget(tt.target)); ext != tt.ext {
+			t.Errorf("target %s, policy %q, mode %q: extension mismatch: have %q, want %q", tt.target, tt.policy, tt.mode, ext, tt.ext)
+		}
+	}
+}
+
+// Tests that the windows extensions are passed to the container for both prefixed
+// and exactly named outputs, whatever the output prefix.
+func TestCompileWindowsExtension(t *testing.T) {
+	tests := []struct {
+		prefix string // Custom output prefix (-out)
+		name   string // Exact output name of windows-amd64 (-name)
+		policy string // Windows extension policy
+		ext    string // Expected FLAG_EXT of windows-amd64 (empty = not passed)
+	}{
+		{policy: "auto", ext: ".exe"},
+		{prefix: "myapp", policy: "auto", ext: ".exe"},
+		{prefix: "myapp.v2", policy: "auto", ext: ".exe"},
+		{policy: "none"},
+		{prefix: "myapp", policy: "none"},
+		{policy: ".scr", ext: ".scr"},
+		{prefix: "myapp", policy: ".scr", ext: ".scr"},
+		{prefix: "myapp", name: "app.exe", policy: "auto", ext: ".exe"},
+		{prefix: "myapp", name: "app", policy: "none"},
+	}
+	for i, tt := range tests {
+		fake := new(fakeRunner)
+		useFakeRunner(t, fake, "24.0.7")
+
+		oldNames := outNames.Overrides
+		outNames.Overrides = make(map[string]string)
+		if tt.name != "" {
+			outNames.Overrides["windows-amd64"] = tt.name
+		}
+		config := &ConfigFlags{Repository: "github.com/project-iris/iris", Prefix: tt.prefix, Targets: "linux-amd64,windows-amd64"}
+		flags := defaultBuildFlags()
+		flags.WinExt = tt.policy
+
+		err := compile("karalabe/xgo-latest", config, flags, "/tmp/out")
+		outNames.Overrides = oldNames
+		if err != nil {
+			t.Fatalf("test %d: failed to compile: %v", i, err)
+		}
+		env := make(map[string]string)
+		for _, call := range fake.matching("docker", "run") {
+			for j := 0; j < len(call)-1; j++ {
+				if call[j] == "-e" {
+					if kv := strings.SplitN(call[j+1], "=", 2); len(kv) == 2 {
+						env[kv[0]] = kv[1]
+					}
+				}
+			}
+		}
+		want := tt.prefix
+		if want == "" {
+			want = "iris"
+		}
+		if env["OUT"] != want {
+			t.Errorf("test %d: output prefix mismatch: have %q, want %q", i, env["OUT"], want)
+		}
+		if ext, ok := env["FLAG_EXT_WINDOWS_AMD64"]; ext != tt.ext || ok != (tt.ext != "") {
+			t.Errorf("test %d: windows extension mismatch: have %q (passed %v), want %q", i, ext, ok, tt.ext)
+		}
+		if _, ok := env["FLAG_EXT_LINUX_AMD64"]; ok {
+			t.Errorf("test %d: unexpected linux extension passed", i)
+		}
+		if name := env["FLAG_NAME_WINDOWS_AMD64"]; name != tt.name {
+			t.Errorf("test %d: exact name mismatch: have %q, want %q", i, name, tt.name)
+		}
+	}
+}
+
+// Tests that repository URLs are converted into import paths, while anything that
+// merely looks similar (import paths, tags, drive letters) is left alone.
+func TestImportPathFromURL(t *testing.T) {
+	tests := []struct {
+		arg  string // Argument given instead of an import path
+		path string // Expected import path if converted
+		ok   bool   // Whether the argument should be treated as a URL
+	}{
+		{"https://github.com/karalabe/xgo", "github.com/karalabe/xgo", true},
+		{"https://github.com/karalabe/xgo/", "github.com/karalabe/xgo", true},
+		{"https://github.com/karalabe/xgo.git", "github.com/karalabe/xgo", true},
+		{"http://gitlab.com/group/sub/project", "gitlab.com/group/sub/project", true},
+		{"git://github.com/karalabe/xgo.git", "github.com/karalabe/xgo", true},
+		{"ssh://git@github.com/karalabe/xgo.git", "github.com/karalabe/xgo", true},
+		{"git+ssh://git@github.com/karalabe/xgo", "github.com/karalabe/xgo", true},
+		{"https://github.com:443/karalabe/xgo", "github.com/karalabe/xgo", true},
+		{"git@github.com:karalabe/xgo.git", "github.com/karalabe/xgo", true},
+		{"git@github.com:karalabe/xgo/", "github.com/karalabe/xgo", true},
+		{"git@myhost:team/repo.git", "myhost/team/repo", true},
+		{"github.com:karalabe/xgo", "github.com/karalabe/xgo", true},
+		{"deploy@git.example.org:team/repo", "git.example.org/team/repo", true},
+		{"https://github.com/karalabe/xgo/tree/master", "github.com/karalabe/xgo", true},
+		{"https://github.com/karalabe/xgo/tree/master/docker/base", "github.com/karalabe/xgo", true},
+		{"https://github.com/karalabe/xgo/blob/master/xgo.go#L10", "github.com/karalabe/xgo", true},
+		{"https://gitlab.com/group/sub/project/-/tree/main", "gitlab.com/group/sub/project", true},
+		{"https://gitlab.com/group/project/-/blob/main/go.mod", "gitlab.com/group/project", true},
+		{"https://github.com/tree/repo", "github.com/tree/repo", true},
+		{"https://github.com/user/blob", "github.com/user/blob", true},
+
+		{"foo:bar", "foo:bar", false},
+		{"user@host:repo", "user@host:repo", false},
+		{"github.com:/abs/path", "github.com:/abs/path", false},
+		{"git@github.com:", "git@github.com:", false},
+		{`C:\src\project`, `C:\src\project`, false},
+		{"C:/src/project", "C:/src/project", false},
+		{"github.com/karalabe/xgo", "github.com/karalabe/xgo", false},
+		{"gopkg.in/yaml.v3", "gopkg.in/yaml.v3", false},
+		{"selftest", "selftest", false},
+		{"https://github.com", "https://github.com", false},
+		{"https://github.com/.git", "https://github.com/.git", false},
+		{"ftp://github.com/karalabe/xgo", "ftp://github.com/karalabe/xgo", false},
+		{"file:///src/project", "file:///src/project", false},
+	}
+	for _, tt := range tests {
+		path, ok := importPathFromURL(tt.arg)
+		if path != tt.path || ok != tt.ok {
+			t.Errorf("%q: conversion mismatch: have (%q, %v), want (%q, %v)", tt.arg, path, ok, tt.path, tt.ok)
+		}
+	}
+}
+
+// Tests that per target flags split comma separated lists, except for the ones
+// with a custom separator, which take their values verbatim.
+func TestTargetFlagSet(t *testing.T) {
+	tests := []struct {
+		sep       string            // Separator of the flag (empty = comma lists)
+		values    []string          // Values the flag is set to, in order
+		def       string            // Expected default value
+		overrides map[string]string // Expected per target values
+	}{
+		{values: []string{"a,b"}, def: "a,b"},
+		{values: []string{"a", "b"}, def: "a,b"},
+		{values: []string{"a,linux-arm=b,c", "d"}, def: "a,d", overrides: map[string]string{"linux-arm": "b,c"}},
+		{sep: " ", values: []string{"-std=c++17", "-O2"}, def: "-std=c++17 -O2"},
+		{sep: " ", values: []string{"-Wl,-O1"}, def: "-Wl,-O1"},
+		{sep: " ", values: []string{"-DNDEBUG -I/deps/include", "-O2"}, def: "-DNDEBUG -I/deps/include -O2"},
+		{sep: " ", values: []string{"-O2", "linux-arm=-O1,-g", "linux-arm=-DARM"}, def: "-O2", overrides: map[string]string{"linux-arm": "-O1,-g -DARM"}},
+	}
+	for i, tt := range tests {
+		f := &targetFlag{Overrides: make(map[string]string), Sep: tt.sep}
+		for _, value := range tt.values {
+			if err := f.Set(value); err != nil {
+				t.Fatalf("test %d: failed to set %q: %v", i, value, err)
+			}
+		}
+		if f.Default != tt.def {
+			t.Errorf("test %d: default mismatch: have %q, want %q", i, f.Default, tt.def)
+		}
+		if len(f.Overrides) != len(tt.overrides) {
+			t.Errorf("test %d: override count mismatch: have %v, want %v", i, f.Overrides, tt.overrides)
+		}
+		for target, want := range tt.overrides {
+			if have := f.Overrides[target]; have != want {
+				t.Errorf("test %d: override of %s mismatch: have %q, want %q", i, target, have, want)
+			}
+		}
+	}
+	if err := (&targetFlag{Overrides: make(map[string]string), Sep: " "}).Set("linux-nope=-O2"); err == nil {
+		t.Errorf("unknown target accepted")
+	}
+}
+
+// Tests that the runtime library paths are embedded the way each platform's linker
+// takes them, quoted so that Go splits the linker flags back correctly.
+func TestTargetLinkerFlags(t *testing.T) {
+	tests := []struct {
+		target  string // Name of the target to link
+		rpath   string // Runtime library search path (-rpath)
+		ldflags string // Expected linker flags of the target
+	}{
+		{"linux-amd64", "", ""},
+		{"linux-amd64", "$ORIGIN/lib", "-extldflags '-Wl,-rpath,$ORIGIN/lib'"},
+		{"linux-amd64", "$ORIGIN/lib:/opt/lib", "-extldflags '-Wl,-rpath,$ORIGIN/lib:/opt/lib'"},
+		{"darwin-amd64", "$ORIGIN/lib", "-extldflags '-Wl,-rpath,@loader_path/lib'"},
+		{"darwin-386", "$ORIGIN/lib:/opt/lib", "-extldflags '-Wl,-rpath,@loader_path/lib -Wl,-rpath,/opt/lib'"},
+		{"ios-arm64", "/opt/lib::$ORIGIN", "-extldflags '-Wl,-rpath,/opt/lib -Wl,-rpath,@loader_path'"},
+		{"windows-amd64", "$ORIGIN/lib", ""},
+		{"linux-amd64", "/opt/bob's libs", `-extldflags "-Wl,-rpath,/opt/bob's libs"`},
+		{"linux-amd64", `/opt/"libs"`, `-extldflags '-Wl,-rpath,/opt/"libs"'`},
+	}
+	for _, tt := range tests {
+		flags := defaultBuildFlags()
+		flags.Rpath = tt.rpath
+		if ldflags := targetLinkerFlags(flags, findTarget(tt.target)); ldflags != tt.ldflags {
+			t.Errorf("target %s, rpath %q: linker flags mismatch: have %q, want %q", tt.target, tt.rpath, ldflags, tt.ldflags)
+		}
+	}
+}
+
+// Tests that the external linkers only apply to the targets whose toolchains can
+// use them.
+func TestLinkerApplies(t *testing.T) {
+	tests := []struct {
+		linker  string // Requested external linker
+		target  string // Name of the target to link
+		applies bool   // Whether the linker should be used
+	}{
+		{"", "linux-amd64", false},
+		{"gold", "linux-amd64", true},
+		{"gold", "linux-386", true},
+		{"gold", "linux-arm", true},
+		{"gold", "linux-riscv64", false},
+		{"gold", "linux-loong64", false},
+		{"gold", "linux-amd64-musl", false},
+		{"gold", "linux-arm64-musl", false},
+		{"gold", "android-arm64", false},
+		{"gold", "windows-amd64", false},
+		{"lld", "linux-amd64-musl", true},
+		{"lld", "android-arm64", true},
+		{"lld", "darwin-amd64", false},
+		{"lld", "ios-arm64", false},
+	}
+	for _, tt := range tests {
+		flags := defaultBuildFlags()
+		flags.Linker = tt.linker
+		if applies := linkerApplies(flags, findTarget(tt.target)); applies != tt.applies {
+			t.Errorf("linker %q, target %s: applicability mismatch: have %v, want %v", tt.linker, tt.target, applies, tt.applies)
+		}
+	}
+	// Targets with CGO disabled are linked internally
+	flags := defaultBuildFlags()
+	flags.Linker = "lld"
+	flags.Cgo.Overrides["linux-amd64"] = "false"
+	if linkerApplies(flags, findTarget("linux-amd64")) {
+		t.Errorf("lld applied to linux-amd64 with CGO disabled")
+	}
+}